@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slack-utils/tokens-rotate/internal/audit"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/internal/slacktest"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/memory"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/sql"
+)
+
+// useStorage makes the commands use s as the storage of type name until the
+// test ends.
+func useStorage(t *testing.T, name string, s rotator.Storage) {
+	factory := storageFactory
+	t.Cleanup(func() {
+		storageFactory = factory
+	})
+
+	storageFactory = func(ctx context.Context, storageType string) (rotator.Storage, error) {
+		if storageType == name {
+			return s, nil
+		}
+
+		return factory(ctx, storageType)
+	}
+}
+
+// setup points the commands at a fake Slack and runs them on a memory
+// storage seeded with token.
+func setup(t *testing.T, slack *slacktest.Server, token shared.Token) rotator.Storage {
+	t.Setenv("ROTATOR_STORAGE", "memory")
+	t.Setenv("ROTATOR_SLACK_API_URL", slack.URL())
+
+	s := memory.New(memory.Options{Seed: token})
+	useStorage(t, "memory", s)
+
+	return s
+}
+
+func execute(t *testing.T, args ...string) {
+	rootCmd.SetArgs(append(args, "--config-path", t.TempDir(), "--log-level", "fatal"))
+	assert.NoError(t, rootCmd.Execute())
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name    string
+		expire  bool
+		rotated bool
+	}{
+		{
+			name: "valid token",
+		},
+		{
+			name:    "expired token",
+			expire:  true,
+			rotated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slack := slacktest.New()
+			defer slack.Close()
+
+			access, refresh, exp := slack.Issue()
+			if tt.expire {
+				slack.Expire(access)
+			}
+
+			s := setup(t, slack, shared.Token{
+				AccessToken:  shared.Secret(access),
+				Exp:          exp,
+				RefreshToken: shared.Secret(refresh),
+			})
+
+			execute(t, "refresh", "--once")
+
+			snapshot, err := s.Load(context.Background())
+			assert.NoError(t, err)
+			assert.True(t, slack.Valid(snapshot.AccessToken.Reveal()))
+			assert.Equal(t, tt.rotated, !slack.Refreshable(refresh))
+			assert.Equal(t, tt.rotated, snapshot.AccessToken.Reveal() != access)
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	slack := slacktest.New()
+	defer slack.Close()
+
+	access, refresh, exp := slack.Issue()
+
+	s := setup(t, slack, shared.Token{
+		AccessToken:  shared.Secret(access),
+		Exp:          exp,
+		RefreshToken: shared.Secret(refresh),
+	})
+
+	execute(t, "rotate")
+
+	snapshot, err := s.Load(context.Background())
+	assert.NoError(t, err)
+	assert.NotEqual(t, access, snapshot.AccessToken.Reveal())
+	assert.True(t, slack.Valid(snapshot.AccessToken.Reveal()))
+	assert.True(t, slack.Refreshable(snapshot.RefreshToken.Reveal()))
+	assert.False(t, slack.Refreshable(refresh))
+	assert.Equal(t, 1, slack.Calls(slacktest.ToolingTokensRotate))
+}
+
+func TestStatus(t *testing.T) {
+	slack := slacktest.New()
+	defer slack.Close()
+
+	access, refresh, exp := slack.Issue()
+
+	setup(t, slack, shared.Token{
+		AccessToken:  shared.Secret(access),
+		Exp:          exp,
+		RefreshToken: shared.Secret(refresh),
+	})
+
+	out := &bytes.Buffer{}
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+
+	execute(t, "status")
+
+	assert.Contains(t, out.String(), "storage:     memory\n")
+	assert.Contains(t, out.String(), "version:     1\n")
+	assert.Contains(t, out.String(), audit.Fingerprint(access))
+	assert.NotContains(t, out.String(), access)
+	assert.NotContains(t, out.String(), refresh)
+}
+
+func TestStatusHistory(t *testing.T) {
+	slack := slacktest.New()
+	defer slack.Close()
+
+	ctx := context.Background()
+	setup(t, slack, shared.Token{})
+	t.Setenv("ROTATOR_STORAGE", "sql")
+
+	s, err := sql.New(ctx, sql.Options{
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "tokens.db"),
+		Name:   "tokens-rotate",
+	})
+	require.NoError(t, err)
+	useStorage(t, "sql", s)
+
+	prev := ""
+	accesses := []string{}
+	for i := 0; i < 2; i++ {
+		access, refresh, exp := slack.Issue()
+		accesses = append(accesses, access)
+
+		token := shared.Token{AccessToken: shared.Secret(access), Exp: exp, RefreshToken: shared.Secret(refresh)}
+		require.NoError(t, s.Store(ctx, shared.Snapshot{Token: token}, prev))
+		prev = fmt.Sprint(i + 1)
+	}
+
+	out := &bytes.Buffer{}
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+	defer func() { statusHistory = false }()
+
+	execute(t, "status", "--history")
+
+	history := out.String()[strings.Index(out.String(), "history:\n"):]
+	assert.Contains(t, history, "  2  "+audit.Fingerprint(accesses[1]))
+	assert.Contains(t, history, "  1  "+audit.Fingerprint(accesses[0]))
+	assert.Less(t, strings.Index(history, "  2  "), strings.Index(history, "  1  "))
+	for _, access := range accesses {
+		assert.NotContains(t, out.String(), access)
+	}
+}
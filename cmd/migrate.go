@@ -0,0 +1,86 @@
+/*
+Copyright © 2023 Denis Halturin <dhalturin@hotmail.com>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+var (
+	migrateFrom      = ""
+	migrateTo        = ""
+	migrateTombstone = false
+	migrateCmd       = &cobra.Command{
+		Use:   "migrate",
+		Short: "Moving tokens from one storage to another",
+		Run: func(cmd *cobra.Command, args []string) {
+			if migrateFrom == migrateTo {
+				log.Fatal("source and destination storages are the same")
+			}
+
+			storages := []rotator.Storage{}
+
+			for _, name := range []string{migrateFrom, migrateTo} {
+				s, err := storageFactory(cmd.Context(), name)
+				if err != nil {
+					log.WithField("err", err).Fatal("failed to create storage")
+				}
+
+				storages = append(storages, s)
+			}
+
+			if err := rotator.Migrate(
+				cmd.Context(),
+				storages[0],
+				storages[1],
+				newSlackClientFactory(),
+				migrateTombstone,
+			); err != nil {
+				log.WithField("err", err).Fatal("migration was failed")
+			}
+
+			log.Infof("tokens were migrated from %s to %s", migrateFrom, migrateTo)
+		},
+	}
+)
+
+func init() {
+	migrateCmd.Flags().StringVar(&migrateFrom, "from", "", "Set the source storage: awssecrets, consul, etcd, fs, memory, redis, s3, sops, sql, vault")
+	migrateCmd.Flags().StringVar(&migrateTo, "to", "", "Set the destination storage: awssecrets, consul, etcd, fs, memory, redis, s3, sops, sql, vault")
+	migrateCmd.Flags().BoolVar(&migrateTombstone, "tombstone", false, "Mark the source storage as moved")
+	migrateCmd.MarkFlagRequired("from")
+	migrateCmd.MarkFlagRequired("to")
+
+	rootCmd.AddCommand(migrateCmd)
+}
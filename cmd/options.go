@@ -0,0 +1,340 @@
+/*
+Copyright © 2023 Denis Halturin <dhalturin@hotmail.com>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/slack-utils/tokens-rotate/internal/notify"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/awssecrets"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/consul"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/etcd"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/fs"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/memory"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/redis"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/s3"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/sops"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/sql"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/storage/vault"
+)
+
+func setDefaults() {
+	viper.SetDefault("storage", "fs")
+	viper.SetDefault("token_kind", rotator.KindConfig)
+	viper.SetDefault("timeouts.slack", 30*time.Second)
+	viper.SetDefault("timeouts.storage", 30*time.Second)
+
+	viper.SetDefault("awssecrets.secret_name", shared.PkgName)
+	viper.SetDefault("consul.key", shared.PkgName)
+	viper.SetDefault("consul.session_ttl", 15*time.Second)
+	viper.SetDefault("etcd.dial_timeout", 5*time.Second)
+	viper.SetDefault("etcd.endpoints", []string{"127.0.0.1:2379"})
+	viper.SetDefault("etcd.key", shared.PkgName)
+	viper.SetDefault("etcd.session_ttl", 15*time.Second)
+	viper.SetDefault("fs.format", "json")
+	viper.SetDefault("fs.token_file", fmt.Sprintf("%s/token.json", shared.PathConf()))
+	viper.SetDefault("redis.addrs", []string{"127.0.0.1:6379"})
+	viper.SetDefault("redis.channel", shared.PkgName)
+	viper.SetDefault("redis.key", shared.PkgName)
+	viper.SetDefault("s3.bucket", shared.PkgName)
+	viper.SetDefault("s3.key", "token.json")
+	viper.SetDefault("sops.file", fmt.Sprintf("%s/token.sops.yaml", shared.PathConf()))
+	viper.SetDefault("sql.driver", "sqlite")
+	viper.SetDefault("sql.dsn", fmt.Sprintf("%s/tokens.db", shared.PathConf()))
+	viper.SetDefault("sql.name", shared.PkgName)
+	viper.SetDefault("vault.secret_name", "secret")
+	viper.SetDefault("vault.secret_path", shared.PkgName)
+
+	viper.SetDefault("audit.syslog.tag", shared.PkgName)
+	viper.SetDefault("journal.file", fmt.Sprintf("%s/journal", shared.PathConf()))
+
+	viper.SetDefault("notify.dedup_window", time.Hour)
+	viper.SetDefault("notify.expiry_threshold", 0)
+	viper.SetDefault("notify.rate_burst", 5)
+	viper.SetDefault("notify.rate_interval", time.Minute)
+	viper.SetDefault("notify.smtp.port", 587)
+}
+
+// addMemoryFlags adds the flags seeding the memory storage to a command
+// building a storage from the configuration. Every command has its own
+// flags, they are bound to the configuration when the command runs.
+func addMemoryFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+
+	flags.String("memory-access-token", "", "Seed the memory storage with an access token")
+	flags.String("memory-refresh-token", "", "Seed the memory storage with a refresh token")
+	flags.Int64("memory-exp", 0, "Seed the memory storage with an expiration time")
+
+	cmd.PreRun = func(cmd *cobra.Command, _ []string) {
+		viper.BindPFlag("memory.access_token", cmd.Flags().Lookup("memory-access-token"))
+		viper.BindPFlag("memory.refresh_token", cmd.Flags().Lookup("memory-refresh-token"))
+		viper.BindPFlag("memory.exp", cmd.Flags().Lookup("memory-exp"))
+	}
+}
+
+// storageFactory builds the storages of the commands, tests replace it.
+var storageFactory = newStorage
+
+func newStorage(ctx context.Context, storageType string) (rotator.Storage, error) {
+	switch storageType {
+	case "awssecrets":
+		return awssecrets.New(ctx, awssecrets.Options{
+			SchemaCompat: viper.GetBool("schema_compat"),
+			SecretName:   viper.GetString("awssecrets.secret_name"),
+			Verify:       rotator.VerifyAuthTest(newSlackClientFactory()),
+		})
+	case "consul":
+		return consul.New(consul.Options{
+			Key:        viper.GetString("consul.key"),
+			LockKey:    viper.GetString("consul.lock_key"),
+			SessionTTL: viper.GetDuration("consul.session_ttl"),
+		})
+	case "etcd":
+		return etcd.New(etcd.Options{
+			CAFile:         viper.GetString("etcd.tls.ca_file"),
+			CertFile:       viper.GetString("etcd.tls.cert_file"),
+			DialTimeout:    viper.GetDuration("etcd.dial_timeout"),
+			ElectionPrefix: viper.GetString("etcd.election_prefix"),
+			Endpoints:      viper.GetStringSlice("etcd.endpoints"),
+			Key:            viper.GetString("etcd.key"),
+			KeyFile:        viper.GetString("etcd.tls.key_file"),
+			Password:       viper.GetString("etcd.password"),
+			SessionTTL:     viper.GetDuration("etcd.session_ttl"),
+			Username:       viper.GetString("etcd.username"),
+		})
+	case "fs":
+		return fs.New(fs.Options{
+			Format:       viper.GetString("fs.format"),
+			SchemaCompat: viper.GetBool("schema_compat"),
+			Template:     viper.GetString("fs.template"),
+			TokenFile:    viper.GetString("fs.token_file"),
+		})
+	case "memory":
+		return memory.New(memory.Options{
+			Seed: rotator.Token{
+				AccessToken:  rotator.Secret(viper.GetString("memory.access_token")),
+				Exp:          viper.GetInt64("memory.exp"),
+				Kind:         viper.GetString("token_kind"),
+				RefreshToken: rotator.Secret(viper.GetString("memory.refresh_token")),
+			},
+		}), nil
+	case "redis":
+		return redis.New(redis.Options{
+			Addrs:            viper.GetStringSlice("redis.addrs"),
+			CAFile:           viper.GetString("redis.tls.ca_file"),
+			CertFile:         viper.GetString("redis.tls.cert_file"),
+			Channel:          viper.GetString("redis.channel"),
+			DB:               viper.GetInt("redis.db"),
+			Key:              viper.GetString("redis.key"),
+			KeyFile:          viper.GetString("redis.tls.key_file"),
+			MasterName:       viper.GetString("redis.master_name"),
+			Password:         viper.GetString("redis.password"),
+			SentinelPassword: viper.GetString("redis.sentinel.password"),
+			SentinelUsername: viper.GetString("redis.sentinel.username"),
+			TLS:              viper.GetBool("redis.tls.enabled"),
+			Username:         viper.GetString("redis.username"),
+		})
+	case "s3":
+		return s3.New(ctx, s3.Options{
+			Bucket:         viper.GetString("s3.bucket"),
+			Endpoint:       viper.GetString("s3.endpoint"),
+			KMSKeyID:       viper.GetString("s3.kms_key_id"),
+			Key:            viper.GetString("s3.key"),
+			Region:         viper.GetString("s3.region"),
+			SSE:            viper.GetString("s3.sse"),
+			SSECustomerKey: viper.GetString("s3.sse_customer_key"),
+			UsePathStyle:   viper.GetBool("s3.use_path_style"),
+		})
+	case "sops":
+		return sops.New(sops.Options{
+			AgeKeyFile:    viper.GetString("sops.age.key_file"),
+			AgeRecipients: viper.GetStringSlice("sops.age.recipients"),
+			File:          viper.GetString("sops.file"),
+			GitCommit:     viper.GetBool("sops.git_commit"),
+			Key:           viper.GetString("sops.key"),
+			PGPKeyring:    viper.GetString("sops.pgp.keyring"),
+			PGPRecipients: viper.GetStringSlice("sops.pgp.recipients"),
+		})
+	case "sql":
+		return sql.New(ctx, sql.Options{
+			Driver: viper.GetString("sql.driver"),
+			DSN:    viper.GetString("sql.dsn"),
+			Name:   viper.GetString("sql.name"),
+		})
+	case "vault":
+		return vault.New(vault.Options{
+			SchemaCompat: viper.GetBool("schema_compat"),
+			SecretName:   viper.GetString("vault.secret_name"),
+			SecretPath:   viper.GetString("vault.secret_path"),
+		})
+	}
+
+	return nil, fmt.Errorf("unknown storage: %s", storageType)
+}
+
+func newAuditLog() (*rotator.AuditLog, error) {
+	return rotator.NewAuditLog(rotator.AuditOptions{
+		Sinks:         viper.GetStringSlice("audit.sinks"),
+		File:          viper.GetString("audit.file"),
+		Key:           viper.GetString("audit.key"),
+		SyslogAddress: viper.GetString("audit.syslog.address"),
+		SyslogNetwork: viper.GetString("audit.syslog.network"),
+		SyslogTag:     viper.GetString("audit.syslog.tag"),
+	})
+}
+
+func newHooks() (*rotator.Hooks, error) {
+	configs := []rotator.HookConfig{}
+	if err := viper.UnmarshalKey("hooks", &configs); err != nil {
+		return nil, err
+	}
+
+	return rotator.NewHooks(configs)
+}
+
+// newJournal returns nil when the journal key is not set.
+func newJournal() (*rotator.Journal, error) {
+	if viper.GetString("journal.key") == "" {
+		return nil, nil
+	}
+
+	return rotator.NewJournal(rotator.JournalOptions{
+		File: viper.GetString("journal.file"),
+		Key:  viper.GetString("journal.key"),
+	})
+}
+
+func newNotifier() (*rotator.Notifier, error) {
+	notifiers := []notify.Notifier{}
+
+	for _, name := range viper.GetStringSlice("notify.notifiers") {
+		switch name {
+		case "slack":
+			notifiers = append(notifiers, notify.NewSlackWebhook(
+				viper.GetString("notify.slack.webhook_url"),
+			))
+		case "smtp":
+			notifiers = append(notifiers, notify.NewSMTP(notify.SMTPOptions{
+				From:     viper.GetString("notify.smtp.from"),
+				Host:     viper.GetString("notify.smtp.host"),
+				Password: viper.GetString("notify.smtp.password"),
+				Port:     viper.GetInt("notify.smtp.port"),
+				To:       viper.GetStringSlice("notify.smtp.to"),
+				Username: viper.GetString("notify.smtp.username"),
+			}))
+		case "webhook":
+			notifiers = append(notifiers, notify.NewHTTPWebhook(
+				viper.GetString("notify.webhook.url"),
+				viper.GetString("notify.webhook.secret"),
+			))
+		default:
+			return nil, fmt.Errorf("unknown notifier: %s", name)
+		}
+	}
+
+	return rotator.NewNotifier(rotator.NotifyOptions{
+		DedupWindow:     viper.GetDuration("notify.dedup_window"),
+		ExpiryThreshold: viper.GetDuration("notify.expiry_threshold"),
+		Notifiers:       notifiers,
+		RateBurst:       viper.GetInt("notify.rate_burst"),
+		RateInterval:    viper.GetDuration("notify.rate_interval"),
+	}), nil
+}
+
+func newServer(app *rotator.App) (*rotator.Server, error) {
+	return rotator.NewServer(app, rotator.ServerOptions{
+		Address:   viper.GetString("serve.address"),
+		Socket:    viper.GetString("serve.socket"),
+		TokenFile: viper.GetString("serve.token_file"),
+		TLSCert:   viper.GetString("serve.tls.cert"),
+		TLSKey:    viper.GetString("serve.tls.key"),
+		ClientCA:  viper.GetString("serve.tls.client_ca"),
+	})
+}
+
+// newSlackClientFactory points the clients at slack.api_url when it is set.
+func newSlackClientFactory() rotator.SlackClientFactory {
+	apiURL := viper.GetString("slack.api_url")
+	if apiURL == "" {
+		return rotator.NewSlackClient
+	}
+
+	return func(token string, options ...slack.Option) rotator.SlackClient {
+		return rotator.NewSlackClient(token, append(options, slack.OptionAPIURL(apiURL))...)
+	}
+}
+
+func newOptions(ctx context.Context) (rotator.Options, error) {
+	opts := rotator.Options{
+		SlackClientFactory: newSlackClientFactory(),
+		SlackTimeout:       viper.GetDuration("timeouts.slack"),
+		StorageTimeout:     viper.GetDuration("timeouts.storage"),
+		Fallback: rotator.Token{
+			AccessToken:  rotator.Secret(viper.GetString("access_token")),
+			Kind:         viper.GetString("token_kind"),
+			RefreshToken: rotator.Secret(viper.GetString("refresh_token")),
+		},
+		OAuthClientID:     viper.GetString("oauth.client_id"),
+		OAuthClientSecret: rotator.Secret(viper.GetString("oauth.client_secret")),
+	}
+
+	var err error
+
+	if opts.Storage, err = storageFactory(ctx, viper.GetString("storage")); err != nil {
+		return opts, err
+	}
+
+	if opts.Audit, err = newAuditLog(); err != nil {
+		return opts, err
+	}
+
+	if opts.Hooks, err = newHooks(); err != nil {
+		return opts, err
+	}
+
+	if opts.Journal, err = newJournal(); err != nil {
+		return opts, err
+	}
+
+	if opts.Notifier, err = newNotifier(); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
@@ -31,38 +31,73 @@ POSSIBILITY OF SUCH DAMAGE.
 package cmd
 
 import (
-	"context"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/slack-go/slack"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 
-	"github.com/slack-utils/tokens-rotate/internal/storage"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
 )
 
-var refreshCmd = &cobra.Command{
-	Use:   "refresh",
-	Short: "Checking and refreshing the access token",
-	Run: func(cmd *cobra.Command, args []string) {
-		s := storage.New(viper.GetString("storage"))
-		c := storage.NewSlack(s, func(token string, options ...slack.Option) storage.SlackClient {
-			return slack.New(token, options...)
-		})
-
-		ctx, stop := signal.NotifyContext(
-			context.Background(),
-			syscall.SIGINT,
-			syscall.SIGTERM,
-		)
-		defer stop()
-
-		c.Run(ctx, time.Minute)
-	},
-}
+var (
+	refreshOnce  = false
+	refreshServe = false
+	refreshCmd   = &cobra.Command{
+		Use:   "refresh",
+		Short: "Checking and refreshing the access token",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := signal.NotifyContext(
+				cmd.Context(),
+				syscall.SIGINT,
+				syscall.SIGTERM,
+			)
+			defer stop()
+
+			opts, err := newOptions(ctx)
+			if err != nil {
+				log.WithField("err", err).Fatal("failed to configure the rotator")
+			}
+			defer opts.Audit.Close()
+
+			c, err := rotator.New(ctx, opts)
+			if err != nil {
+				log.WithField("err", err).Fatal("failed to create the rotator")
+			}
+
+			if refreshOnce {
+				if err := c.Check(ctx); err != nil {
+					log.WithField("err", err).Fatal("rotation was failed")
+				}
+
+				return
+			}
+
+			if refreshServe {
+				srv, err := newServer(c)
+				if err != nil {
+					log.WithField("err", err).Fatal("failed to configure the API")
+				}
+
+				go func() {
+					if err := srv.Serve(ctx); err != nil {
+						log.WithField("err", err).Fatal("API was stopped")
+					}
+				}()
+			}
+
+			if err := c.Run(ctx, time.Minute); err != nil {
+				log.WithField("err", err).Fatal("rotation was stopped")
+			}
+		},
+	}
+)
 
 func init() {
 	rootCmd.AddCommand(refreshCmd)
+
+	refreshCmd.Flags().BoolVar(&refreshOnce, "once", false, "Check the access token once and exit")
+	refreshCmd.Flags().BoolVar(&refreshServe, "serve", false, "Serve the current token over the local API")
+	addMemoryFlags(refreshCmd)
 }
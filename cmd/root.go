@@ -42,6 +42,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/slack-utils/tokens-rotate/internal/redact"
 	"github.com/slack-utils/tokens-rotate/internal/shared"
 )
 
@@ -74,14 +75,17 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Set the log format: text, json")
 	rootCmd.PersistentFlags().BoolVar(&logFormatJsonPretty, "log-pretty", false, "Json logs will be indented")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "error", "Set the log level: debug, info, warn, error, fatal")
+	rootCmd.PersistentFlags().Bool("schema-compat", false, "Write tokens without the schema version for older releases")
+
+	viper.BindPFlag("schema_compat", rootCmd.PersistentFlags().Lookup("schema-compat"))
 }
 
 func initConfig() {
 	viper.AutomaticEnv()
 	viper.SetConfigFile(fmt.Sprintf("%s/%s", configPath, configFile))
-	viper.SetDefault("storage", "fs")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.SetEnvPrefix("rotator")
+	setDefaults()
 
 	level, err := log.ParseLevel(logLevel)
 	if err != nil {
@@ -98,6 +102,9 @@ func initConfig() {
 		},
 	)
 
+	// Hooks fire in order, tokens must be scrubbed before the writers.
+	log.AddHook(redact.NewHook())
+
 	log.AddHook(&writer.Hook{
 		Writer: os.Stderr,
 		LogLevels: []log.Level{
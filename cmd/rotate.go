@@ -0,0 +1,82 @@
+/*
+Copyright © 2023 Denis Halturin <dhalturin@hotmail.com>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotating the access token right away",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(
+			cmd.Context(),
+			syscall.SIGINT,
+			syscall.SIGTERM,
+		)
+		defer stop()
+
+		opts, err := newOptions(ctx)
+		if err != nil {
+			log.WithField("err", err).Fatal("failed to configure the rotator")
+		}
+		defer opts.Audit.Close()
+
+		c, err := rotator.New(ctx, opts)
+		if err != nil {
+			log.WithField("err", err).Fatal("failed to create the rotator")
+		}
+
+		token, err := c.Rotate(ctx)
+		if err != nil {
+			log.WithField("err", err).Fatal("rotation was failed")
+		}
+
+		if !c.Stored() {
+			log.Fatal("rotated token was not saved")
+		}
+
+		log.WithField("exp", token.Exp).Info("token was rotated")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rotateCmd)
+
+	addMemoryFlags(rotateCmd)
+}
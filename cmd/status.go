@@ -0,0 +1,107 @@
+/*
+Copyright © 2023 Denis Halturin <dhalturin@hotmail.com>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/slack-utils/tokens-rotate/internal/audit"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+var statusHistory bool
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Showing the token kept in the storage",
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := storageFactory(cmd.Context(), viper.GetString("storage"))
+		if err != nil {
+			log.WithField("err", err).Fatal("failed to create storage")
+		}
+
+		snapshot, err := s.Load(cmd.Context())
+		if err != nil {
+			log.WithField("err", err).Fatal("failed to read storage")
+		}
+
+		w := cmd.OutOrStdout()
+
+		fmt.Fprintf(w, "storage:     %s\n", s.StorageGetName())
+		fmt.Fprintf(w, "version:     %s\n", snapshot.Version)
+		fmt.Fprintf(w, "kind:        %s\n", snapshot.Kind)
+		fmt.Fprintf(w, "fingerprint: %s\n", audit.Fingerprint(snapshot.AccessToken.Reveal()))
+		fmt.Fprintf(w, "exp:         %s\n", time.Unix(snapshot.Exp, 0).UTC().Format(time.RFC3339))
+
+		if snapshot.MovedTo != "" {
+			fmt.Fprintf(w, "moved to:    %s\n", snapshot.MovedTo)
+		}
+
+		if !statusHistory {
+			return
+		}
+
+		h, ok := s.(rotator.Historian)
+		if !ok {
+			log.WithField("storage", s.StorageGetName()).Fatal("the storage doesn't keep the token history")
+		}
+
+		revisions, err := h.History(cmd.Context())
+		if err != nil {
+			log.WithField("err", err).Fatal("failed to read the token history")
+		}
+
+		fmt.Fprintln(w, "history:")
+		for _, r := range revisions {
+			fingerprint, exp := "-", "-"
+			if r.Fingerprint != "" {
+				fingerprint = r.Fingerprint
+			}
+			if r.Exp != 0 {
+				exp = time.Unix(r.Exp, 0).UTC().Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "  %s  %s  %s  %s\n", r.Created.UTC().Format(time.RFC3339), r.Version, fingerprint, exp)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	addMemoryFlags(statusCmd)
+	statusCmd.Flags().BoolVar(&statusHistory, "history", false, "List the tokens saved before, for storages keeping them")
+}
@@ -0,0 +1,255 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	Check           = "check"
+	EnvFallback     = "env_fallback"
+	HookFailed      = "hook_failed"
+	HookSucceeded   = "hook_succeeded"
+	RotateAttempted = "rotate_attempted"
+	RotateFailed    = "rotate_failed"
+	RotateSucceeded = "rotate_succeeded"
+	SaveFailed      = "save_failed"
+	SaveSucceeded   = "save_succeeded"
+)
+
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Storage     string    `json:"storage"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Exp         int64     `json:"exp,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	PrevHash    string    `json:"prev_hash"`
+	Hash        string    `json:"hash"`
+}
+
+type Sink interface {
+	Write([]byte) error
+	Close() error
+}
+
+// Header is implemented by sinks continuing an existing chain, Head returns
+// the hash of the last entry written before.
+type Header interface {
+	Head() string
+}
+
+// chain is a sink with the hash of the last event it has written.
+type chain struct {
+	sink Sink
+	prev string
+}
+
+// Log chains every event to the previous one by hash, so removing or
+// editing an entry breaks the chain and is detected by Verify. Every sink
+// has its own chain, an event a sink failed to write is not linked to. With
+// a key the hashes are HMACs, so an entry can't be rewritten along with the
+// hashes following it without the key.
+type Log struct {
+	mu     sync.Mutex
+	key    []byte
+	chains []*chain
+}
+
+func (l *Log) Emit(e Event) {
+	if l == nil || len(l.chains) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	for _, c := range l.chains {
+		e.PrevHash = c.prev
+
+		hash, err := hashEvent(l.key, e)
+		if err != nil {
+			log.WithField("err", err).Error("failed to hash audit event")
+
+			return
+		}
+		e.Hash = hash
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.WithField("err", err).Error("failed to marshal audit event")
+
+			return
+		}
+
+		if err := c.sink.Write(data); err != nil {
+			log.WithField("err", err).Error("failed to write audit event")
+
+			continue
+		}
+
+		c.prev = hash
+	}
+}
+
+func (l *Log) Close() {
+	if l == nil {
+		return
+	}
+
+	for _, c := range l.chains {
+		if err := c.sink.Close(); err != nil {
+			log.WithField("err", err).Error("failed to close audit sink")
+		}
+	}
+}
+
+// hashEvent returns the SHA-256 of the event, or its HMAC-SHA256 when key is
+// set.
+func hashEvent(key []byte, e Event) (string, error) {
+	e.Hash = ""
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	if len(key) == 0 {
+		sum := sha256.Sum256(data)
+
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Fingerprint identifies a token without revealing it.
+func Fingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(token))
+
+	return "sha256:" + hex.EncodeToString(sum[:])[:16]
+}
+
+// Verify checks that every entry of a JSON-lines audit log is intact and
+// references the hash of the entry before it. key is the key the log was
+// written with. When head is set, the log must end with the entry of that
+// hash, so the removed last entries are detected too.
+func Verify(r io.Reader, key, head string) error {
+	scanner := bufio.NewScanner(r)
+	prev := ""
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		e := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if e.PrevHash != prev {
+			return fmt.Errorf("line %d: chain is broken", line)
+		}
+
+		hash, err := hashEvent([]byte(key), e)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if !hmac.Equal([]byte(hash), []byte(e.Hash)) {
+			return fmt.Errorf("line %d: entry was modified", line)
+		}
+
+		prev = e.Hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if head != "" && prev != head {
+		return fmt.Errorf("log doesn't end with the entry %s, it was truncated", head)
+	}
+
+	return nil
+}
+
+type Options struct {
+	// Sinks lists the enabled sinks: file, stdout, syslog.
+	Sinks []string
+	File  string
+	// Key makes the hashes HMACs, an entry can't be rewritten without it.
+	Key string
+	// Syslog connection, the local daemon is used when the network is empty.
+	SyslogAddress string
+	SyslogNetwork string
+	SyslogTag     string
+}
+
+func NewWithSinks(key string, sinks ...Sink) *Log {
+	l := &Log{key: []byte(key)}
+
+	for _, s := range sinks {
+		c := &chain{sink: s}
+		if h, ok := s.(Header); ok {
+			c.prev = h.Head()
+		}
+
+		l.chains = append(l.chains, c)
+	}
+
+	return l
+}
+
+func New(opts Options) (*Log, error) {
+	sinks := []Sink{}
+
+	for _, name := range opts.Sinks {
+		switch name {
+		case "file":
+			s, err := NewFileSink(opts.File)
+			if err != nil {
+				return nil, err
+			}
+
+			sinks = append(sinks, s)
+		case "stdout":
+			sinks = append(sinks, NewStdoutSink())
+		case "syslog":
+			s, err := NewSyslogSink(opts.SyslogNetwork, opts.SyslogAddress, opts.SyslogTag)
+			if err != nil {
+				return nil, err
+			}
+
+			sinks = append(sinks, s)
+		default:
+			return nil, fmt.Errorf("unknown audit sink: %s", name)
+		}
+	}
+
+	return NewWithSinks(opts.Key, sinks...), nil
+}
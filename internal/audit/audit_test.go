@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+
+	for _, kind := range []string{Check, RotateAttempted, RotateSucceeded} {
+		s, err := NewFileSink(file)
+		assert.NoError(t, err)
+
+		l := NewWithSinks("", s)
+		l.Emit(Event{
+			Type:        kind,
+			Storage:     "test",
+			Fingerprint: Fingerprint("xoxe.xoxp-1-access"),
+		})
+		l.Close()
+	}
+
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "xoxe.xoxp-1-access")
+	assert.NoError(t, Verify(bytes.NewReader(data), "", ""))
+
+	lines := strings.SplitAfter(string(data), "\n")
+
+	deleted := strings.Join(append([]string{lines[0]}, lines[2:]...), "")
+	assert.Error(t, Verify(strings.NewReader(deleted), "", ""))
+
+	modified := strings.Replace(string(data), `"type":"check"`, `"type":"save_succeeded"`, 1)
+	assert.Error(t, Verify(strings.NewReader(modified), "", ""))
+}
+
+// failingSink fails the writes while broken.
+type failingSink struct {
+	bytes.Buffer
+	broken bool
+}
+
+func (s *failingSink) Write(data []byte) error {
+	if s.broken {
+		return errors.New("sink is down")
+	}
+
+	s.Buffer.Write(append(data, '\n'))
+
+	return nil
+}
+
+func (s *failingSink) Close() error {
+	return nil
+}
+
+func TestLogSinkFailure(t *testing.T) {
+	healthy := &failingSink{}
+	flaky := &failingSink{}
+	l := NewWithSinks("", healthy, flaky)
+
+	l.Emit(Event{Type: Check, Storage: "test"})
+	flaky.broken = true
+	l.Emit(Event{Type: RotateAttempted, Storage: "test"})
+	flaky.broken = false
+	l.Emit(Event{Type: RotateSucceeded, Storage: "test"})
+
+	// the event missing from a sink doesn't break its chain
+	assert.NoError(t, Verify(bytes.NewReader(healthy.Bytes()), "", ""))
+	assert.NoError(t, Verify(bytes.NewReader(flaky.Bytes()), "", ""))
+	assert.Equal(t, 3, strings.Count(healthy.String(), "\n"))
+	assert.Equal(t, 2, strings.Count(flaky.String(), "\n"))
+}
+
+func TestLogKey(t *testing.T) {
+	keyed := &failingSink{}
+	l := NewWithSinks("secret", keyed)
+
+	for _, kind := range []string{Check, RotateAttempted, RotateSucceeded} {
+		l.Emit(Event{Type: kind, Storage: "test"})
+	}
+
+	data := keyed.String()
+	assert.NoError(t, Verify(strings.NewReader(data), "secret", ""))
+	assert.Error(t, Verify(strings.NewReader(data), "", ""))
+	assert.Error(t, Verify(strings.NewReader(data), "other", ""))
+
+	// the log can't be rewritten with the hashes computed again without the key
+	rewritten := &failingSink{}
+	r := NewWithSinks("", rewritten)
+	for _, kind := range []string{Check, RotateFailed} {
+		r.Emit(Event{Type: kind, Storage: "test"})
+	}
+	assert.Error(t, Verify(strings.NewReader(rewritten.String()), "secret", ""))
+
+	// the removed last entries are detected against the head
+	lines := strings.SplitAfter(data, "\n")
+	e := Event{}
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &e))
+
+	assert.NoError(t, Verify(strings.NewReader(data), "secret", e.Hash))
+	truncated := strings.Join(lines[:2], "")
+	assert.NoError(t, Verify(strings.NewReader(truncated), "secret", ""))
+	assert.Error(t, Verify(strings.NewReader(truncated), "secret", e.Hash))
+}
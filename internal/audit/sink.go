@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+)
+
+type writerSink struct {
+	w io.Writer
+}
+
+func (s *writerSink) Write(data []byte) error {
+	_, err := fmt.Fprintf(s.w, "%s\n", data)
+
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok && s.w != os.Stdout {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func NewStdoutSink() Sink {
+	return &writerSink{w: os.Stdout}
+}
+
+type fileSink struct {
+	writerSink
+	head string
+}
+
+func (s *fileSink) Head() string {
+	return s.head
+}
+
+// NewFileSink opens a JSON-lines file for appending, its chain continues
+// from the last entry of the file across restarts.
+func NewFileSink(file string) (Sink, error) {
+	if file == "" {
+		return nil, fmt.Errorf("audit file is not set")
+	}
+
+	head, err := lastHash(file)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileSink{writerSink: writerSink{w: f}, head: head}, nil
+}
+
+func lastHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+	defer f.Close()
+
+	last := ""
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		e := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return "", err
+		}
+
+		last = e.Hash
+	}
+
+	return last, scanner.Err()
+}
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func (s *syslogSink) Write(data []byte) error {
+	return s.w.Info(string(data))
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
+
+// NewSyslogSink connects to the local syslog daemon when network is empty.
+func NewSyslogSink(network, address, tag string) (Sink, error) {
+	w, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogSink{w: w}, nil
+}
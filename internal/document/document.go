@@ -0,0 +1,158 @@
+// Package document versions the token document written by the storages.
+//
+// Documents without the schema field are schema 1: the bare token fields as
+// written by older releases, where vault keeps exp as a string and the JSON
+// storages keep it as a number. Reading upgrades a document through the
+// registered migrations, writing always produces the current schema unless
+// the storage runs in compat mode.
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+// Current is the schema written by this release.
+const Current = 2
+
+// Document is a decoded token document, as parsed from JSON or read from the
+// KV fields of a secret.
+type Document map[string]any
+
+// Migration upgrades a document by one schema version in place.
+type Migration func(Document) error
+
+var migrations = map[int]Migration{}
+
+// Register adds the migration upgrading documents of schema from to
+// schema from+1.
+func Register(from int, m Migration) {
+	migrations[from] = m
+}
+
+func init() {
+	Register(1, upgradeV1)
+}
+
+// upgradeV1 normalizes exp to a number, vault stored it as a string.
+func upgradeV1(doc Document) error {
+	if value, ok := doc["exp"].(string); ok {
+		exp, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid exp: %w", err)
+		}
+		doc["exp"] = exp
+	}
+
+	return nil
+}
+
+func schema(doc Document) (int, error) {
+	value, ok := doc["schema"]
+	if !ok {
+		return 1, nil
+	}
+
+	v, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema: %v", value)
+	}
+
+	return v, nil
+}
+
+// Upgrade applies the registered migrations until doc is at Current.
+func Upgrade(doc Document) error {
+	v, err := schema(doc)
+	if err != nil {
+		return err
+	}
+
+	if v > Current {
+		return fmt.Errorf("schema %d is newer than the supported %d", v, Current)
+	}
+
+	for ; v < Current; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return fmt.Errorf("no migration from schema %d", v)
+		}
+
+		if err := m(doc); err != nil {
+			return fmt.Errorf("failed to migrate from schema %d: %w", v, err)
+		}
+	}
+
+	doc["schema"] = Current
+
+	return nil
+}
+
+// Decode upgrades doc and returns the token it holds.
+func Decode(doc Document) (shared.Token, error) {
+	token := shared.Token{}
+
+	if err := Upgrade(doc); err != nil {
+		return token, err
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return token, err
+	}
+
+	return token, json.Unmarshal(data, &token)
+}
+
+// Encode returns token as a document of the current schema.
+func Encode(token shared.Token) Document {
+	doc := Document{
+		"schema":        Current,
+		"access_token":  token.AccessToken.Reveal(),
+		"exp":           token.Exp,
+		"refresh_token": token.RefreshToken.Reveal(),
+	}
+
+	if token.Kind != "" {
+		doc["kind"] = token.Kind
+	}
+
+	if token.MovedTo != "" {
+		doc["moved_to"] = token.MovedTo
+	}
+
+	return doc
+}
+
+type current struct {
+	Schema int `json:"schema"`
+	shared.Token
+}
+
+// Marshal returns the JSON document of token. With compat the schema 1 shape
+// is written, so older releases sharing the storage can still read it.
+func Marshal(token shared.Token, compat bool) ([]byte, error) {
+	if compat {
+		return json.Marshal(token)
+	}
+
+	return json.Marshal(current{Schema: Current, Token: token})
+}
+
+// Unmarshal decodes a JSON document of any known schema.
+func Unmarshal(data []byte) (shared.Token, error) {
+	doc := Document{}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	if err := d.Decode(&doc); err != nil {
+		return shared.Token{}, err
+	}
+
+	return Decode(doc)
+}
@@ -0,0 +1,79 @@
+package document
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+func TestUnmarshal(t *testing.T) {
+	token := shared.Token{
+		AccessToken:  "access-token",
+		Exp:          1685800000,
+		RefreshToken: "refresh-token",
+	}
+
+	tests := []struct {
+		name  string
+		data  string
+		error bool
+	}{
+		{
+			name: "schema 1 with numeric exp",
+			data: `{"access_token":"access-token","exp":1685800000,"refresh_token":"refresh-token"}`,
+		},
+		{
+			name: "schema 1 with string exp",
+			data: `{"access_token":"access-token","exp":"1685800000","refresh_token":"refresh-token"}`,
+		},
+		{
+			name: "schema 2",
+			data: `{"schema":2,"access_token":"access-token","exp":1685800000,"refresh_token":"refresh-token"}`,
+		},
+		{
+			name:  "newer schema",
+			data:  `{"schema":3,"access_token":"access-token","exp":1685800000,"refresh_token":"refresh-token"}`,
+			error: true,
+		},
+		{
+			name:  "invalid exp",
+			data:  `{"access_token":"access-token","exp":"soon","refresh_token":"refresh-token"}`,
+			error: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Unmarshal([]byte(tt.data))
+			if tt.error {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, token, got)
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	token := shared.Token{
+		AccessToken:  "access-token",
+		Exp:          123,
+		RefreshToken: "refresh-token",
+	}
+
+	data, err := Marshal(token, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"schema":2,"access_token":"access-token","exp":123,"refresh_token":"refresh-token"}`, string(data))
+
+	data, err = Marshal(token, true)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"access_token":"access-token","exp":123,"refresh_token":"refresh-token"}`, string(data))
+
+	got, err := Decode(Encode(token))
+	assert.NoError(t, err)
+	assert.Equal(t, token, got)
+}
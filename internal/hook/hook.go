@@ -0,0 +1,106 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+// Hook notifies a token consumer after the rotated token has been saved.
+type Hook interface {
+	Name() string
+	Run(context.Context, shared.Token) error
+}
+
+type Config struct {
+	Type         string        `mapstructure:"type"`
+	Command      []string      `mapstructure:"command"`
+	IncludeToken bool          `mapstructure:"include_token"`
+	Pidfile      string        `mapstructure:"pidfile"`
+	Signal       string        `mapstructure:"signal"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	URL          string        `mapstructure:"url"`
+}
+
+type Result struct {
+	Name string
+	Err  error
+}
+
+type Runner struct {
+	hooks    []Hook
+	timeouts []time.Duration
+}
+
+// Run executes every hook with its own timeout. A failed hook doesn't stop
+// the others.
+func (r *Runner) Run(ctx context.Context, token shared.Token) []Result {
+	if r == nil {
+		return nil
+	}
+
+	results := make([]Result, 0, len(r.hooks))
+
+	for i, h := range r.hooks {
+		hookCtx, cancel := context.WithTimeout(ctx, r.timeouts[i])
+
+		log.WithField("hook", h.Name()).Info("running hook")
+		results = append(results, Result{
+			Name: h.Name(),
+			Err:  h.Run(hookCtx, token),
+		})
+
+		cancel()
+	}
+
+	return results
+}
+
+func (r *Runner) Add(h Hook, timeout time.Duration) {
+	r.hooks = append(r.hooks, h)
+	r.timeouts = append(r.timeouts, timeout)
+}
+
+func NewHook(c Config) (Hook, error) {
+	switch c.Type {
+	case "exec":
+		if len(c.Command) == 0 {
+			return nil, fmt.Errorf("exec hook requires a command")
+		}
+
+		return &Exec{command: c.Command}, nil
+	case "http":
+		if c.URL == "" {
+			return nil, fmt.Errorf("http hook requires an url")
+		}
+
+		return &HTTP{includeToken: c.IncludeToken, url: c.URL}, nil
+	case "signal":
+		return NewSignal(c.Pidfile, c.Signal)
+	}
+
+	return nil, fmt.Errorf("unknown hook type: %s", c.Type)
+}
+
+func New(configs []Config) (*Runner, error) {
+	r := &Runner{}
+
+	for _, c := range configs {
+		h, err := NewHook(c)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.Timeout <= 0 {
+			c.Timeout = 30 * time.Second
+		}
+
+		r.Add(h, c.Timeout)
+	}
+
+	return r, nil
+}
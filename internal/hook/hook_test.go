@@ -0,0 +1,82 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+func TestRunner(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	pidfile := filepath.Join(dir, "pid")
+	token := shared.Token{
+		AccessToken:  "xoxe.xoxp-1-access",
+		Exp:          123,
+		Kind:         shared.KindOAuth,
+		RefreshToken: "xoxe-1-refresh",
+	}
+
+	assert.NoError(t, os.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0600))
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	sig, err := NewSignal(pidfile, "sighup")
+	assert.NoError(t, err)
+
+	r := &Runner{}
+	r.Add(&Exec{command: []string{"sh", "-c", `echo "$SLACK_TOKEN $SLACK_TOKEN_EXP $SLACK_TOKEN_KIND $SLACK_CONFIG_TOKEN $SLACK_CONFIG_TOKEN_EXP $*" > ` + out, "hook", "arg"}}, time.Second)
+	r.Add(&Exec{command: []string{"sleep", "5"}}, 10*time.Millisecond)
+	r.Add(sig, time.Second)
+
+	results := r.Run(context.Background(), token)
+
+	assert.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	assert.Error(t, results[1].Err)
+	assert.NoError(t, results[2].Err)
+
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "xoxe.xoxp-1-access 123 oauth xoxe.xoxp-1-access 123 arg\n", string(data))
+
+	select {
+	case <-hup:
+	case <-time.After(time.Second):
+		t.Error("signal was not delivered")
+	}
+}
+
+func TestExecOutput(t *testing.T) {
+	token := shared.Token{
+		AccessToken:  "xoxe.xoxp-1-access",
+		Exp:          123,
+		RefreshToken: "xoxe-1-refresh",
+	}
+
+	e := &Exec{command: []string{
+		"sh", "-c",
+		`echo "token=$SLACK_CONFIG_TOKEN exp=$SLACK_CONFIG_TOKEN_EXP refresh=$SLACK_CONFIG_REFRESH_TOKEN"; printf 'x%.0s' $(seq 1000); exit 1`,
+	}}
+
+	err := e.Run(context.Background(), token)
+	assert.Error(t, err)
+
+	msg := err.Error()
+	assert.Contains(t, msg, "token=[REDACTED] exp=123 refresh=\n")
+	assert.NotContains(t, msg, "xoxe")
+	assert.True(t, strings.HasSuffix(msg, "..."))
+	assert.Less(t, len(msg), maxOutput+50)
+}
@@ -0,0 +1,174 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/slack-utils/tokens-rotate/internal/audit"
+	"github.com/slack-utils/tokens-rotate/internal/redact"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+// maxOutput is the length of the command output kept in an error.
+const maxOutput = 256
+
+// Exec runs a command with the new access token, its expiry and kind in the
+// environment. The token is never passed as an argument, so it doesn't show
+// up in the process list, and the refresh token never leaves the rotator.
+// SLACK_CONFIG_TOKEN and SLACK_CONFIG_TOKEN_EXP are kept for the hooks
+// written when only configuration tokens were rotated.
+type Exec struct {
+	command []string
+}
+
+func (e *Exec) Name() string {
+	return "exec:" + e.command[0]
+}
+
+func (e *Exec) Run(ctx context.Context, token shared.Token) error {
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Env = append(
+		os.Environ(),
+		"SLACK_TOKEN="+token.AccessToken.Reveal(),
+		fmt.Sprintf("SLACK_TOKEN_EXP=%d", token.Exp),
+		"SLACK_TOKEN_KIND="+token.Kind,
+		"SLACK_CONFIG_TOKEN="+token.AccessToken.Reveal(),
+		fmt.Sprintf("SLACK_CONFIG_TOKEN_EXP=%d", token.Exp),
+	)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, output(out))
+	}
+
+	return nil
+}
+
+// output returns the command output for an error, the tokens the command
+// may have printed are redacted and the output is truncated.
+func output(out []byte) string {
+	s := redact.String(string(bytes.TrimSpace(out)))
+	if len(s) > maxOutput {
+		s = strings.ToValidUTF8(s[:maxOutput], "") + "..."
+	}
+
+	return s
+}
+
+type HTTP struct {
+	client       *http.Client
+	includeToken bool
+	url          string
+}
+
+type payload struct {
+	AccessToken string `json:"access_token,omitempty"`
+	Exp         int64  `json:"exp"`
+	Fingerprint string `json:"fingerprint"`
+	Kind        string `json:"kind,omitempty"`
+}
+
+func (h *HTTP) Name() string {
+	return "http:" + h.url
+}
+
+func (h *HTTP) Run(ctx context.Context, token shared.Token) error {
+	p := payload{
+		Exp:         token.Exp,
+		Fingerprint: audit.Fingerprint(token.AccessToken.Reveal()),
+		Kind:        token.Kind,
+	}
+
+	if h.includeToken {
+		p.AccessToken = token.AccessToken.Reveal()
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("hook returned %s", res.Status)
+	}
+
+	return nil
+}
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// Signal sends a signal to the process whose PID is stored in the pidfile.
+type Signal struct {
+	pidfile string
+	signal  syscall.Signal
+}
+
+func NewSignal(pidfile, name string) (*Signal, error) {
+	if pidfile == "" {
+		return nil, fmt.Errorf("signal hook requires a pidfile")
+	}
+
+	if name == "" {
+		name = "SIGHUP"
+	}
+
+	sig, ok := signals[strings.ToUpper(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown signal: %s", name)
+	}
+
+	return &Signal{pidfile: pidfile, signal: sig}, nil
+}
+
+func (s *Signal) Name() string {
+	return "signal:" + s.pidfile
+}
+
+func (s *Signal) Run(_ context.Context, _ shared.Token) error {
+	data, err := os.ReadFile(s.pidfile)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pidfile: %w", err)
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	return p.Signal(s.signal)
+}
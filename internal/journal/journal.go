@@ -0,0 +1,170 @@
+package journal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+// The journal file starts with a header of the magic and the random salt
+// the encryption key is derived with, followed by the nonce and the sealed
+// token. The header is authenticated along with the token.
+const (
+	magic    = "TRJ1"
+	saltSize = 16
+)
+
+// scrypt parameters recommended for interactive logins, deriving a key
+// takes tens of milliseconds once per write and read.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Journal keeps an encrypted copy of a freshly rotated token on the local
+// disk until the storage confirms that it was saved.
+type Journal struct {
+	file       string
+	passphrase []byte
+	l          *log.Entry
+}
+
+// cipher derives the encryption key from the passphrase and salt.
+func (j *Journal) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(j.passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Write replaces the journal with token, the file and its directory are
+// synced before Write returns.
+func (j *Journal) Write(token shared.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, len(magic)+saltSize)
+	copy(header, magic)
+	if _, err := io.ReadFull(rand.Reader, header[len(magic):]); err != nil {
+		return err
+	}
+
+	gcm, err := j.cipher(header[len(magic):])
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	sealed := gcm.Seal(append(header, nonce...), nonce, data, header)
+
+	if err := shared.WriteFile(j.file, sealed, 0600); err != nil {
+		return err
+	}
+
+	j.l.Debug("token was written to the journal")
+
+	return nil
+}
+
+// Read returns the unflushed token or nil if the journal is empty.
+func (j *Journal) Read() (*shared.Token, error) {
+	data, err := os.ReadFile(j.file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	size := len(magic) + saltSize
+	if len(data) < size || !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, fmt.Errorf("journal file is corrupted")
+	}
+
+	header, data := data[:size], data[size:]
+
+	gcm, err := j.cipher(header[len(magic):])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("journal file is corrupted")
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("cant decrypt journal file: %w", err)
+	}
+
+	token := &shared.Token{}
+	if err := json.Unmarshal(plain, token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (j *Journal) Clear() error {
+	if err := os.Remove(j.file); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	if err := shared.SyncDir(filepath.Dir(j.file)); err != nil {
+		return err
+	}
+
+	j.l.Debug("journal was cleared")
+
+	return nil
+}
+
+type Options struct {
+	File string
+	// Key is a passphrase the journal encryption key is derived from with
+	// scrypt and a random salt stored in the journal file.
+	Key string
+}
+
+func New(opts Options) (*Journal, error) {
+	if opts.File == "" || opts.Key == "" {
+		return nil, fmt.Errorf("journal file and key are required")
+	}
+
+	return &Journal{
+		file:       opts.File,
+		passphrase: []byte(opts.Key),
+		l:          log.WithField("journal", filepath.Base(opts.File)),
+	}, nil
+}
@@ -0,0 +1,67 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+func TestJournal(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "journal")
+	token := shared.Token{
+		AccessToken:  "access-token",
+		Exp:          123,
+		RefreshToken: "refresh-token",
+	}
+
+	j, err := New(Options{File: file, Key: "test-key"})
+	assert.NoError(t, err)
+
+	empty, err := j.Read()
+	assert.NoError(t, err)
+	assert.Nil(t, empty)
+
+	assert.NoError(t, j.Write(token))
+
+	stored, err := j.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, &token, stored)
+
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.NoFileExists(t, file+".tmp")
+
+	// every write derives the key with a new salt
+	first, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.NoError(t, j.Write(token))
+	second, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, first[:len(magic)], second[:len(magic)])
+	assert.NotEqual(t, first[:len(magic)+saltSize], second[:len(magic)+saltSize])
+
+	// a changed header is rejected
+	second[len(magic)] ^= 1
+	assert.NoError(t, os.WriteFile(file, second, 0600))
+	_, err = j.Read()
+	assert.Error(t, err)
+	assert.NoError(t, os.WriteFile(file, first, 0600))
+
+	other, err := New(Options{File: file, Key: "wrong-key"})
+	assert.NoError(t, err)
+
+	_, err = other.Read()
+	assert.Error(t, err)
+
+	assert.NoError(t, j.Clear())
+	assert.NoError(t, j.Clear())
+
+	empty, err = j.Read()
+	assert.NoError(t, err)
+	assert.Nil(t, empty)
+}
@@ -0,0 +1,219 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+type SlackWebhook struct {
+	url string
+}
+
+func NewSlackWebhook(url string) *SlackWebhook {
+	return &SlackWebhook{url: url}
+}
+
+func (s *SlackWebhook) Notify(ctx context.Context, n Notification) error {
+	return slack.PostWebhookContext(ctx, s.url, &slack.WebhookMessage{
+		Text: n.Text(),
+	})
+}
+
+// HTTPWebhook posts the notification as JSON. The body is signed with
+// HMAC-SHA256 and the signature is sent in the X-Signature-256 header.
+type HTTPWebhook struct {
+	client *http.Client
+	secret string
+	url    string
+}
+
+func NewHTTPWebhook(url, secret string) *HTTPWebhook {
+	return &HTTPWebhook{secret: secret, url: url}
+}
+
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *HTTPWebhook) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-256", Sign(h.secret, body))
+
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+
+	return nil
+}
+
+// smtpTimeout bounds an SMTP session whose context has no deadline.
+const smtpTimeout = 30 * time.Second
+
+type SMTPOptions struct {
+	From     string
+	Host     string
+	Password string
+	Port     int
+	To       []string
+	Username string
+}
+
+type SMTP struct {
+	from     string
+	host     string
+	password string
+	port     int
+	to       []string
+	username string
+}
+
+func NewSMTP(opts SMTPOptions) *SMTP {
+	return &SMTP{
+		from:     opts.From,
+		host:     opts.Host,
+		password: opts.Password,
+		port:     opts.Port,
+		to:       opts.To,
+		username: opts.Username,
+	}
+}
+
+// Notify sends the message, the connection is closed when ctx is done and
+// every read and write is bounded by the deadline of ctx or smtpTimeout.
+func (s *SMTP) Notify(ctx context.Context, n Notification) error {
+	d := net.Dialer{}
+
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(s.host, strconv.Itoa(s.port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	if err := s.send(conn, n); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// the connection shares the deadline of ctx and may hit it first
+		if ok && errors.Is(err, os.ErrDeadlineExceeded) {
+			return context.DeadlineExceeded
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// send delivers the message like smtp.SendMail on an open connection.
+func (s *SMTP) send(conn net.Conn, n Notification) error {
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(
+		w,
+		"From: %s\r\nTo: %s\r\nSubject: tokens-rotate: %s\r\n\r\n%s\r\n",
+		s.from,
+		strings.Join(s.to, ", "),
+		n.Kind,
+		n.Text(),
+	); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
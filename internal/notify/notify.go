@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	ChainBroken    = "chain_broken"
+	ExpirySoon     = "expiry_soon"
+	HookFailed     = "hook_failed"
+	RotationFailed = "rotation_failed"
+	SaveFailed     = "save_failed"
+)
+
+type Notification struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Storage string    `json:"storage"`
+	Exp     int64     `json:"exp,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func (n Notification) Text() string {
+	text := ""
+
+	switch n.Kind {
+	case ChainBroken:
+		text = "Slack refresh token chain is broken, generate a new configuration token at https://api.slack.com/apps"
+	case ExpirySoon:
+		text = fmt.Sprintf("Slack access token expires at %s", time.Unix(n.Exp, 0).UTC().Format(time.RFC3339))
+	case HookFailed:
+		text = "Post-rotation hook failed, the new token is saved"
+	case RotationFailed:
+		text = "Slack access token rotation failed"
+	case SaveFailed:
+		text = "Rotated Slack token could not be saved"
+	}
+
+	text = fmt.Sprintf("[%s] %s", n.Storage, text)
+
+	if n.Error != "" {
+		text = fmt.Sprintf("%s: %s", text, n.Error)
+	}
+
+	return text
+}
+
+type Notifier interface {
+	Notify(context.Context, Notification) error
+}
+
+// Dispatcher delivers notifications to every notifier, dropping repeats of
+// the same kind for the same storage within the dedup window and anything
+// above the rate limit. Every kind has its own limit, so a burst of failed
+// rotations doesn't suppress a broken chain.
+type Dispatcher struct {
+	mu        sync.Mutex
+	burst     int
+	dedup     time.Duration
+	expiry    time.Duration
+	interval  time.Duration
+	limiters  map[string]*rate.Limiter
+	notifiers []Notifier
+	sent      map[string]time.Time
+	timeout   time.Duration
+}
+
+// ExpiryThreshold returns how long before the expiration an ExpirySoon
+// notification is sent, zero disables it.
+func (d *Dispatcher) ExpiryThreshold() time.Duration {
+	if d == nil {
+		return 0
+	}
+
+	return d.expiry
+}
+
+func (d *Dispatcher) Notify(ctx context.Context, n Notification) {
+	if d == nil || len(d.notifiers) == 0 {
+		return
+	}
+
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+
+	if !d.allow(n) {
+		log.WithField("kind", n.Kind).Debug("notification was suppressed")
+
+		return
+	}
+
+	for _, notifier := range d.notifiers {
+		notifyCtx, cancel := context.WithTimeout(ctx, d.timeout)
+
+		if err := notifier.Notify(notifyCtx, n); err != nil {
+			log.WithField("err", err).Error("failed to send notification")
+		}
+
+		cancel()
+	}
+}
+
+func (d *Dispatcher) allow(n Notification) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the error text changes between attempts, e.g. with a request ID, it
+	// doesn't make a notification new
+	key := strings.Join([]string{n.Kind, n.Storage}, "\x00")
+
+	if last, ok := d.sent[key]; ok && n.Time.Sub(last) < d.dedup {
+		return false
+	}
+
+	limiter, ok := d.limiters[n.Kind]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Every(d.interval), d.burst)
+		d.limiters[n.Kind] = limiter
+	}
+
+	if !limiter.AllowN(n.Time, 1) {
+		return false
+	}
+
+	d.sent[key] = n.Time
+
+	return true
+}
+
+type Options struct {
+	// DedupWindow suppresses repeats of the same notification.
+	DedupWindow time.Duration
+	// ExpiryThreshold enables ExpirySoon notifications when positive.
+	ExpiryThreshold time.Duration
+	Notifiers       []Notifier
+	// RateBurst notifications of a kind are sent per RateInterval.
+	RateBurst    int
+	RateInterval time.Duration
+	// Timeout of a single notifier call, 10s by default.
+	Timeout time.Duration
+}
+
+func New(opts Options) *Dispatcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	if opts.RateBurst <= 0 {
+		opts.RateBurst = 1
+	}
+
+	return &Dispatcher{
+		burst:     opts.RateBurst,
+		dedup:     opts.DedupWindow,
+		expiry:    opts.ExpiryThreshold,
+		interval:  opts.RateInterval,
+		limiters:  map[string]*rate.Limiter{},
+		notifiers: opts.Notifiers,
+		sent:      map[string]time.Time{},
+		timeout:   opts.Timeout,
+	}
+}
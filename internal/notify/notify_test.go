@@ -0,0 +1,181 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type NotifierMock struct {
+	mock.Mock
+}
+
+func (n *NotifierMock) Notify(_ context.Context, notification Notification) error {
+	args := n.Called(notification.Kind)
+	return args.Error(0)
+}
+
+func TestDispatcher(t *testing.T) {
+	n := &NotifierMock{}
+	n.On("Notify", RotationFailed).Return(nil).Twice()
+	n.On("Notify", SaveFailed).Return(nil).Once()
+	n.On("Notify", ChainBroken).Return(nil).Once()
+
+	d := New(Options{
+		DedupWindow:  time.Hour,
+		Notifiers:    []Notifier{n},
+		RateBurst:    2,
+		RateInterval: time.Hour,
+	})
+
+	// a repeat is suppressed even with another error text
+	d.Notify(context.Background(), Notification{Kind: RotationFailed, Storage: "test", Error: "timeout"})
+	d.Notify(context.Background(), Notification{Kind: RotationFailed, Storage: "test", Error: "request abc: timeout"})
+
+	// the rate limit of a kind stops a flood of storages
+	d.Notify(context.Background(), Notification{Kind: RotationFailed, Storage: "first", Error: "timeout"})
+	d.Notify(context.Background(), Notification{Kind: RotationFailed, Storage: "second", Error: "timeout"})
+
+	// and doesn't suppress other kinds
+	d.Notify(context.Background(), Notification{Kind: SaveFailed, Storage: "test", Error: "timeout"})
+	d.Notify(context.Background(), Notification{Kind: ChainBroken, Storage: "test", Error: "invalid_refresh_token"})
+
+	n.AssertExpectations(t)
+}
+
+func TestHTTPWebhook(t *testing.T) {
+	secret := "test-secret"
+	received := Notification{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, Sign(secret, body), r.Header.Get("X-Signature-256"))
+		assert.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	h := NewHTTPWebhook(server.URL, secret)
+
+	assert.NoError(t, h.Notify(context.Background(), Notification{
+		Kind:    ChainBroken,
+		Storage: "test",
+		Error:   "invalid_refresh_token",
+	}))
+	assert.Equal(t, ChainBroken, received.Kind)
+	assert.Equal(t, "invalid_refresh_token", received.Error)
+}
+
+// smtpServer answers an SMTP session on l and returns the message data. With
+// hang it only accepts the connection.
+func smtpServer(t *testing.T, l net.Listener, hang bool) <-chan string {
+	data := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if hang {
+			io.Copy(io.Discard, conn)
+			return
+		}
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) {
+			_, err := io.WriteString(conn, s+"\r\n")
+			assert.NoError(t, err)
+		}
+
+		reply("220 localhost ESMTP")
+
+		msg := strings.Builder{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				reply("250 OK")
+			case "DATA":
+				reply("354 go ahead")
+
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					msg.WriteString(line)
+				}
+
+				data <- msg.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 " + cmd)
+			}
+		}
+	}()
+
+	return data
+}
+
+func newSMTP(t *testing.T, l net.Listener) *SMTP {
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	assert.NoError(t, err)
+
+	p, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	return NewSMTP(SMTPOptions{
+		From: "tokens-rotate@example.com",
+		Host: host,
+		Port: p,
+		To:   []string{"ops@example.com"},
+	})
+}
+
+func TestSMTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	data := smtpServer(t, l, false)
+
+	assert.NoError(t, newSMTP(t, l).Notify(context.Background(), Notification{Kind: ChainBroken, Storage: "test"}))
+	assert.Contains(t, <-data, "Subject: tokens-rotate: chain_broken\r\n")
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	smtpServer(t, l, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = newSMTP(t, l).Notify(ctx, Notification{Kind: ChainBroken, Storage: "test"})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
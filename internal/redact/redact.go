@@ -0,0 +1,64 @@
+// Package redact scrubs Slack tokens from log entries.
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+const placeholder = "[REDACTED]"
+
+// pattern matches config (xoxe.xoxp-), refresh (xoxe-), user (xoxp-) and
+// bot (xoxb-) tokens.
+var pattern = regexp.MustCompile(`xox[bpe](\.xox[bp])?-[0-9A-Za-z-]+`)
+
+// String replaces every token in s with a placeholder.
+func String(s string) string {
+	return pattern.ReplaceAllString(s, placeholder)
+}
+
+// Hook is a logrus hook scrubbing tokens from the message and fields of every
+// entry. It is a backstop for values that were not wrapped in shared.Secret.
+type Hook struct{}
+
+func NewHook() *Hook {
+	return &Hook{}
+}
+
+func (h *Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *Hook) Fire(entry *log.Entry) error {
+	entry.Message = String(entry.Message)
+
+	for k, v := range entry.Data {
+		entry.Data[k] = value(v)
+	}
+
+	return nil
+}
+
+func value(v any) any {
+	switch v := v.(type) {
+	case shared.Secret:
+		return v.String()
+	case shared.Token, *shared.Token, shared.Snapshot, *shared.Snapshot:
+		return fmt.Sprintf("%+v", v)
+	case string:
+		return String(v)
+	case error:
+		if s := String(v.Error()); s != v.Error() {
+			return errors.New(s)
+		}
+	case fmt.Stringer:
+		return String(v.String())
+	}
+
+	return v
+}
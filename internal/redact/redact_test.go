@@ -0,0 +1,66 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+func TestHook(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(*log.Logger)
+	}{
+		{
+			name: "message",
+			log: func(l *log.Logger) {
+				l.Infof("token %s", "xoxb-123-abc")
+			},
+		},
+		{
+			name: "string field",
+			log: func(l *log.Logger) {
+				l.WithField("token", "xoxp-123-abc").Info("test")
+			},
+		},
+		{
+			name: "error field",
+			log: func(l *log.Logger) {
+				l.WithField("err", errors.New("invalid token xoxe-1-abc")).Info("test")
+			},
+		},
+		{
+			name: "secret field",
+			log: func(l *log.Logger) {
+				l.WithField("token", shared.Secret("abc")).Info("test")
+			},
+		},
+		{
+			name: "token field",
+			log: func(l *log.Logger) {
+				l.WithField("token", shared.Token{AccessToken: "abc"}).Info("test")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+
+			l := log.New()
+			l.SetOutput(buf)
+			l.SetFormatter(&log.JSONFormatter{})
+			l.AddHook(NewHook())
+
+			tt.log(l)
+
+			assert.NotContains(t, buf.String(), "abc")
+			assert.Contains(t, buf.String(), placeholder)
+		})
+	}
+}
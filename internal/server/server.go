@@ -0,0 +1,316 @@
+// Package server exposes the token held by the rotator to local consumers.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+// Rotator is the state the API serves, implemented by rotator.App.
+type Rotator interface {
+	Token() shared.Token
+	Rotate(context.Context) (shared.Token, error)
+}
+
+type Options struct {
+	// Address is the TCP address to listen on, TLS is used when TLSCert is
+	// set. Only a loopback address may be used without TLS.
+	Address string
+	// Socket is the path of the Unix socket to listen on, it is created
+	// with 0600 permissions.
+	Socket string
+	// TokenFile holds the bearer token clients must send.
+	TokenFile string
+
+	TLSCert string
+	TLSKey  string
+	// ClientCA enables mTLS, clients with a certificate signed by it don't
+	// need the bearer token.
+	ClientCA string
+}
+
+type Server struct {
+	bearer    string
+	l         *log.Entry
+	opts      Options
+	rotator   Rotator
+	tlsConfig *tls.Config
+}
+
+// Response is the body of both endpoints.
+type Response struct {
+	AccessToken string `json:"access_token,omitempty"`
+	Exp         int64  `json:"exp,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+func New(r Rotator, opts Options) (*Server, error) {
+	if opts.Address == "" && opts.Socket == "" {
+		return nil, fmt.Errorf("address or socket is required")
+	}
+
+	s := &Server{
+		l:       log.WithField("component", "server"),
+		opts:    opts,
+		rotator: r,
+	}
+
+	if opts.TokenFile != "" {
+		data, err := os.ReadFile(opts.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token: %w", err)
+		}
+
+		if s.bearer = strings.TrimSpace(string(data)); s.bearer == "" {
+			return nil, fmt.Errorf("bearer token file is empty")
+		}
+	}
+
+	if opts.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+
+		s.tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+
+		if opts.ClientCA != "" {
+			pem, err := os.ReadFile(opts.ClientCA)
+			if err != nil {
+				return nil, err
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %s", opts.ClientCA)
+			}
+
+			s.tlsConfig.ClientCAs = pool
+			s.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	} else if opts.ClientCA != "" {
+		return nil, fmt.Errorf("client CA requires a TLS certificate")
+	}
+
+	if opts.Address != "" && s.bearer == "" && opts.ClientCA == "" {
+		return nil, fmt.Errorf("bearer token file or client CA is required to listen on %s", opts.Address)
+	}
+
+	if opts.Address != "" && s.tlsConfig == nil && !loopback(opts.Address) {
+		return nil, fmt.Errorf("TLS certificate is required to listen on %s, only loopback addresses are served in plain text", opts.Address)
+	}
+
+	return s, nil
+}
+
+// loopback reports whether address listens on the loopback interface only.
+// A host name other than localhost may resolve to anything and is not.
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// listenSocket creates the socket in a private directory next to path and
+// renames it into place once its permissions are restricted, so no one can
+// connect to it in between.
+func listenSocket(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	// the socket is removed by Serve, the listener knows only the old path
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Handler returns the API handler. Requests on the socket are trusted when
+// no bearer token is configured, the socket permissions restrict them.
+func (s *Server) Handler(socket bool) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			s.write(w, http.StatusMethodNotAllowed, Response{Error: "method not allowed"})
+			return
+		}
+
+		s.write(w, http.StatusOK, response(s.rotator.Token()))
+	})
+
+	mux.HandleFunc("/v1/rotate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			s.write(w, http.StatusMethodNotAllowed, Response{Error: "method not allowed"})
+			return
+		}
+
+		token, err := s.rotator.Rotate(r.Context())
+		if err != nil {
+			s.l.WithField("err", err).Error("forced rotation was failed")
+			s.write(w, http.StatusBadGateway, Response{Error: err.Error()})
+			return
+		}
+
+		s.write(w, http.StatusOK, response(token))
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r, socket) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.write(w, http.StatusUnauthorized, Response{Error: "unauthorized"})
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) authorized(r *http.Request, socket bool) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	if s.bearer == "" {
+		return socket
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.bearer)) == 1
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, res Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		s.l.WithField("err", err).Error("failed to write response")
+	}
+}
+
+func response(token shared.Token) Response {
+	return Response{
+		AccessToken: token.AccessToken.Reveal(),
+		Exp:         token.Exp,
+		Kind:        token.Kind,
+	}
+}
+
+// Serve listens on the configured address and socket until the context is
+// done.
+func (s *Server) Serve(ctx context.Context) error {
+	servers := []*http.Server{}
+	errs := make(chan error, 2)
+
+	serve := func(l net.Listener, socket bool) {
+		srv := &http.Server{
+			Handler:           s.Handler(socket),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		servers = append(servers, srv)
+
+		go func() {
+			if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+
+	if s.opts.Socket != "" {
+		l, err := listenSocket(s.opts.Socket)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(s.opts.Socket)
+
+		s.l.WithField("socket", s.opts.Socket).Info("serving the token")
+		serve(l, true)
+	}
+
+	if s.opts.Address != "" {
+		l, err := net.Listen("tcp", s.opts.Address)
+		if err != nil {
+			return err
+		}
+
+		if s.tlsConfig != nil {
+			l = tls.NewListener(l, s.tlsConfig)
+		}
+
+		s.l.WithField("address", s.opts.Address).Info("serving the token")
+		serve(l, false)
+	}
+
+	var err error
+
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, srv := range servers {
+		srv.Shutdown(shutdown)
+	}
+
+	return err
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+type fakeRotator struct {
+	token   shared.Token
+	err     error
+	rotated int
+}
+
+func (f *fakeRotator) Token() shared.Token {
+	return f.token
+}
+func (f *fakeRotator) Rotate(_ context.Context) (shared.Token, error) {
+	f.rotated++
+	return f.token, f.err
+}
+
+func TestHandler(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "bearer")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0600))
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		bearer  string
+		socket  bool
+		err     error
+		status  int
+		rotated int
+	}{
+		{
+			name:   "token",
+			method: http.MethodGet,
+			path:   "/v1/token",
+			bearer: "secret",
+			status: http.StatusOK,
+		},
+		{
+			name:   "wrong bearer",
+			method: http.MethodGet,
+			path:   "/v1/token",
+			bearer: "wrong",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "socket still requires the bearer",
+			method: http.MethodGet,
+			path:   "/v1/token",
+			socket: true,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:    "rotate",
+			method:  http.MethodPost,
+			path:    "/v1/rotate",
+			bearer:  "secret",
+			status:  http.StatusOK,
+			rotated: 1,
+		},
+		{
+			name:    "rotation failed",
+			method:  http.MethodPost,
+			path:    "/v1/rotate",
+			bearer:  "secret",
+			err:     fmt.Errorf("invalid_refresh_token"),
+			status:  http.StatusBadGateway,
+			rotated: 1,
+		},
+		{
+			name:   "wrong method",
+			method: http.MethodGet,
+			path:   "/v1/rotate",
+			bearer: "secret",
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeRotator{
+				token: shared.Token{AccessToken: "xoxe.xoxp-1-access", Exp: 123},
+				err:   tt.err,
+			}
+
+			s, err := New(r, Options{Address: "127.0.0.1:0", TokenFile: tokenFile})
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+
+			rec := httptest.NewRecorder()
+			s.Handler(tt.socket).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.rotated, r.rotated)
+
+			res := Response{}
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+
+			if tt.status == http.StatusOK {
+				assert.Equal(t, Response{AccessToken: "xoxe.xoxp-1-access", Exp: 123}, res)
+			} else {
+				assert.NotEmpty(t, res.Error)
+			}
+		})
+	}
+}
+
+func TestAddress(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "bearer")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0600))
+
+	tests := []struct {
+		address string
+		err     bool
+	}{
+		{address: "127.0.0.1:8080"},
+		{address: "[::1]:8080"},
+		{address: "localhost:8080"},
+		{address: ":8080", err: true},
+		{address: "0.0.0.0:8080", err: true},
+		{address: "10.0.0.1:8080", err: true},
+		{address: "example.com:8080", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			// plain text is served on loopback addresses only
+			_, err := New(&fakeRotator{}, Options{Address: tt.address, TokenFile: tokenFile})
+			assert.Equal(t, tt.err, err != nil, err)
+		})
+	}
+}
+
+func TestSocket(t *testing.T) {
+	_, err := New(&fakeRotator{}, Options{Address: "127.0.0.1:0"})
+	assert.Error(t, err)
+
+	s, err := New(&fakeRotator{}, Options{Socket: filepath.Join(t.TempDir(), "sock")})
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	s.Handler(true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/token", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestServeSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "sock")
+
+	s, err := New(&fakeRotator{token: shared.Token{AccessToken: "xoxe.xoxp-1-access"}}, Options{Socket: socket})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(socket)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	info, err := os.Stat(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the private directory the socket was created in is gone
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	c := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	res, err := c.Get("http://unix/v1/token")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.NoFileExists(t, socket)
+}
@@ -0,0 +1,55 @@
+package shared
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces name with data so that either the old or the new
+// content survives a crash. The data is written to a temporary file next to
+// name and synced before the rename, then the directory is synced.
+func WriteFile(name string, data []byte, perm os.FileMode) error {
+	tmp := name + ".tmp"
+
+	if err := SyncFile(tmp, data, perm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	return SyncDir(filepath.Dir(name))
+}
+
+// SyncFile writes data to name and flushes it to the disk.
+func SyncFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// SyncDir flushes the entries of dir, so a file created, renamed or removed
+// in it stays so after a crash.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,41 @@
+package shared
+
+import (
+	"fmt"
+)
+
+const redacted = "[REDACTED]"
+
+// Secret is a string that never prints its value. Formatting it with any
+// verb, including %#v and logrus fields, gives a placeholder. The value is
+// available only through Reveal. JSON and YAML encoding still write the real
+// value, storages rely on it.
+type Secret string
+
+// Reveal returns the real value.
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+func (s Secret) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprint(f, s.GoString())
+	case verb == 'q':
+		fmt.Fprintf(f, "%q", s.String())
+	default:
+		fmt.Fprint(f, s.String())
+	}
+}
@@ -0,0 +1,22 @@
+package shared
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecret(t *testing.T) {
+	token := Token{
+		AccessToken:  "xoxe.xoxp-1-access",
+		RefreshToken: "xoxe-1-refresh",
+	}
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v", "%q"} {
+		assert.NotContains(t, fmt.Sprintf(format, token), "access", format)
+		assert.NotContains(t, fmt.Sprintf(format, token.RefreshToken), "refresh", format)
+	}
+
+	assert.Equal(t, "xoxe.xoxp-1-access", token.AccessToken.Reveal())
+}
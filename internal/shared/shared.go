@@ -1,59 +1,102 @@
 package shared
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
+)
+
+// Token kinds. Config tokens are rotated with tooling.tokens.rotate, bot and
+// user tokens of apps with token rotation enabled are refreshed through
+// oauth.v2.access. An empty kind is a config token.
+const (
+	KindConfig = "config"
+	KindOAuth  = "oauth"
 )
 
 type Token struct {
-	AccessToken  string `json:"access_token"`
-	Exp          int64  `json:"exp"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken  Secret `json:"access_token" yaml:"access_token"`
+	Exp          int64  `json:"exp" yaml:"exp"`
+	RefreshToken Secret `json:"refresh_token" yaml:"refresh_token"`
+	Kind         string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	MovedTo      string `json:"moved_to,omitempty" yaml:"moved_to,omitempty"`
+}
+
+// Snapshot is a token as it was read from or written to a storage. Version
+// identifies the stored revision and is empty when nothing is stored yet.
+type Snapshot struct {
+	Token
+
+	Version string
+}
+
+// Revision is a token saved in the past by a storage keeping its history.
+// Fingerprint is empty and Exp is zero when the storage doesn't record them.
+type Revision struct {
+	Version     string
+	Fingerprint string
+	Exp         int64
+	Created     time.Time
 }
 
+var (
+	ErrNotFound        = errors.New("token not found")
+	ErrVersionConflict = errors.New("stored token was changed concurrently")
+	// ErrTokenRejected is wrapped by checks that got a conclusive answer
+	// from Slack that a token is not valid.
+	ErrTokenRejected = errors.New("token was rejected by slack")
+	// ErrNotLeader is returned when another daemon holds the rotation lock,
+	// also by stores of a daemon that lost it.
+	ErrNotLeader = errors.New("rotation lock is held by another daemon")
+)
+
+// GeneralStorage implements the token accessors of storages written against
+// the getter/setter interface.
 type GeneralStorage struct {
 	Token
 }
 
 func (gs *GeneralStorage) TokenGetAccess() string {
-	return gs.AccessToken
+	return gs.AccessToken.Reveal()
 }
 
 func (gs *GeneralStorage) TokenGetExpirationTime() int64 {
 	return gs.Exp
 }
 
+func (gs *GeneralStorage) TokenGetKind() string {
+	return gs.Kind
+}
+
+func (gs *GeneralStorage) TokenGetMovedTo() string {
+	return gs.MovedTo
+}
+
 func (gs *GeneralStorage) TokenGetRefresh() string {
-	return gs.RefreshToken
+	return gs.RefreshToken.Reveal()
 }
 
 func (gs *GeneralStorage) TokenSetAccess(token string) {
-	gs.AccessToken = token
+	gs.AccessToken = Secret(token)
 }
 
 func (gs *GeneralStorage) TokenSetExpirationTime(exp int64) {
 	gs.Exp = exp
 }
 
-func (gs *GeneralStorage) TokenSetRefresh(token string) {
-	gs.RefreshToken = token
+func (gs *GeneralStorage) TokenSetKind(kind string) {
+	gs.Kind = kind
+}
+
+func (gs *GeneralStorage) TokenSetMovedTo(storage string) {
+	gs.MovedTo = storage
 }
 
-func (gs *GeneralStorage) LoadTokensFromEnv() {
-	log.Info("importing tokens from environment variables")
-
-	gs.TokenSetAccess(viper.GetString("access_token"))
-	gs.TokenSetRefresh(viper.GetString("refresh_token"))
-	gs.TokenSetExpirationTime(
-		time.
-			Now().
-			Add(time.Hour * time.Duration(12)).
-			Unix(),
-	)
+func (gs *GeneralStorage) TokenSetRefresh(token string) {
+	gs.RefreshToken = Secret(token)
 }
 
 var (
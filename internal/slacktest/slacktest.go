@@ -0,0 +1,281 @@
+// Package slacktest is a fake Slack Web API for offline tests. It implements
+// auth.test and tooling.tokens.rotate with the semantics of config tokens:
+// refresh tokens are single use, access tokens expire at exp, and failures,
+// ratelimits and latency can be injected per method.
+package slacktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthTest            = "auth.test"
+	ToolingTokensRotate = "tooling.tokens.rotate"
+)
+
+// Fault is returned by the next call of a method instead of its result.
+type Fault struct {
+	// Status is the HTTP status, 200 by default.
+	Status int
+	// Error is the Slack error code of the response.
+	Error string
+	// RetryAfter is sent with 429 responses.
+	RetryAfter time.Duration
+}
+
+type Server struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	access  map[string]int64
+	calls   map[string]int
+	faults  map[string][]Fault
+	latency time.Duration
+	now     func() time.Time
+	refresh map[string]bool
+	seq     int
+	ttl     time.Duration
+}
+
+// New starts the server, stop it with Close.
+func New() *Server {
+	s := &Server{
+		access:  map[string]int64{},
+		calls:   map[string]int{},
+		faults:  map[string][]Fault{},
+		now:     time.Now,
+		refresh: map[string]bool{},
+		ttl:     12 * time.Hour,
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// URL is the API URL for slack.OptionAPIURL.
+func (s *Server) URL() string {
+	return s.srv.URL + "/"
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// SetClock replaces the time source used for iat and exp.
+func (s *Server) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = now
+}
+
+// SetTTL sets the lifetime of issued access tokens, 12 hours by default.
+func (s *Server) SetTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ttl = ttl
+}
+
+// SetLatency delays every response.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = d
+}
+
+// Fail queues faults returned by the next calls of method.
+func (s *Server) Fail(method string, faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults[method] = append(s.faults[method], faults...)
+}
+
+// RateLimit makes the next call of method answer 429.
+func (s *Server) RateLimit(method string, retryAfter time.Duration) {
+	s.Fail(method, Fault{
+		Status:     http.StatusTooManyRequests,
+		Error:      "ratelimited",
+		RetryAfter: retryAfter,
+	})
+}
+
+// Calls returns how many times method was called.
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[method]
+}
+
+// Issue creates a valid token pair.
+func (s *Server) Issue() (access, refresh string, exp int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.issue()
+}
+
+// Expire makes an access token expired.
+func (s *Server) Expire(access string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.access[access]; ok {
+		s.access[access] = s.now().Unix()
+	}
+}
+
+// Valid reports whether access is a known unexpired access token.
+func (s *Server) Valid(access string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exp, ok := s.access[access]
+
+	return ok && s.now().Unix() < exp
+}
+
+// Refreshable reports whether refresh can still be used.
+func (s *Server) Refreshable(refresh string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.refresh[refresh]
+}
+
+func (s *Server) issue() (access, refresh string, exp int64) {
+	s.seq++
+
+	access = fmt.Sprintf("xoxe.xoxp-1-%d", s.seq)
+	refresh = fmt.Sprintf("xoxe-1-%d", s.seq)
+	exp = s.now().Add(s.ttl).Unix()
+
+	s.access[access] = exp
+	s.refresh[refresh] = true
+
+	return access, refresh, exp
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/")
+
+	s.mu.Lock()
+	s.calls[method]++
+	latency := s.latency
+
+	var fault *Fault
+	if faults := s.faults[method]; len(faults) > 0 {
+		fault = &faults[0]
+		s.faults[method] = faults[1:]
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(latency):
+		}
+	}
+
+	if fault != nil {
+		if fault.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(fault.RetryAfter/time.Second)))
+		}
+
+		status := fault.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		write(w, status, map[string]any{"ok": false, "error": fault.Error})
+
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		write(w, http.StatusBadRequest, map[string]any{"ok": false, "error": "invalid_form_data"})
+		return
+	}
+
+	switch method {
+	case AuthTest:
+		s.authTest(w, r)
+	case ToolingTokensRotate:
+		s.rotate(w, r)
+	default:
+		write(w, http.StatusOK, map[string]any{"ok": false, "error": "unknown_method"})
+	}
+}
+
+func (s *Server) authTest(w http.ResponseWriter, r *http.Request) {
+	token := r.Form.Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+
+	s.mu.Lock()
+	exp, ok := s.access[token]
+	now := s.now().Unix()
+	s.mu.Unlock()
+
+	switch {
+	case token == "":
+		write(w, http.StatusOK, map[string]any{"ok": false, "error": "not_authed"})
+	case !ok:
+		write(w, http.StatusOK, map[string]any{"ok": false, "error": "invalid_auth"})
+	case now >= exp:
+		write(w, http.StatusOK, map[string]any{"ok": false, "error": "token_expired"})
+	default:
+		write(w, http.StatusOK, map[string]any{
+			"ok":      true,
+			"team":    "test",
+			"team_id": "T0000000000",
+			"user":    "test",
+			"user_id": "U0000000000",
+		})
+	}
+}
+
+func (s *Server) rotate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refresh := r.Form.Get("refresh_token")
+	if !s.refresh[refresh] {
+		write(w, http.StatusOK, map[string]any{"ok": false, "error": "invalid_refresh_token"})
+		return
+	}
+
+	// refresh tokens are single use
+	delete(s.refresh, refresh)
+
+	access, next, exp := s.issue()
+
+	write(w, http.StatusOK, map[string]any{
+		"ok":            true,
+		"token":         access,
+		"refresh_token": next,
+		"team_id":       "T0000000000",
+		"user_id":       "U0000000000",
+		"iat":           s.now().Unix(),
+		"exp":           exp,
+	})
+}
+
+func write(w http.ResponseWriter, status int, body map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
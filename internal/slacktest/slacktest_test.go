@@ -0,0 +1,60 @@
+package slacktest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	now := time.Unix(1000, 0)
+	s.SetClock(func() time.Time { return now })
+
+	access, refresh, exp := s.Issue()
+	assert.Equal(t, now.Add(12*time.Hour).Unix(), exp)
+
+	api := slack.New(access, slack.OptionAPIURL(s.URL()))
+	_, err := api.AuthTestContext(context.Background())
+	assert.NoError(t, err)
+
+	rotated, err := api.ToolingTokensRotateContext(context.Background(), refresh)
+	assert.NoError(t, err)
+	assert.True(t, s.Valid(rotated.Token))
+	assert.False(t, s.Refreshable(refresh))
+
+	_, err = api.ToolingTokensRotateContext(context.Background(), refresh)
+	assert.EqualError(t, err, "invalid_refresh_token")
+
+	now = now.Add(12 * time.Hour)
+	_, err = api.AuthTestContext(context.Background())
+	assert.EqualError(t, err, "token_expired")
+
+	_, err = slack.New("unknown", slack.OptionAPIURL(s.URL())).AuthTestContext(context.Background())
+	assert.EqualError(t, err, "invalid_auth")
+
+	s.Fail(AuthTest, Fault{Error: "fatal_error"})
+	_, err = api.AuthTestContext(context.Background())
+	assert.EqualError(t, err, "fatal_error")
+
+	s.RateLimit(ToolingTokensRotate, time.Second)
+	_, err = api.ToolingTokensRotateContext(context.Background(), rotated.RefreshToken)
+	limited := &slack.RateLimitedError{}
+	assert.True(t, errors.As(err, &limited))
+	assert.Equal(t, time.Second, limited.RetryAfter)
+
+	s.SetLatency(200 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = api.AuthTestContext(ctx)
+	assert.Error(t, err)
+
+	assert.Equal(t, 5, s.Calls(AuthTest))
+	assert.Equal(t, 3, s.Calls(ToolingTokensRotate))
+}
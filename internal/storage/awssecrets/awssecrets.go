@@ -2,112 +2,281 @@ package awssecrets
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 
+	"github.com/slack-utils/tokens-rotate/internal/document"
 	"github.com/slack-utils/tokens-rotate/internal/shared"
 )
 
+type Options struct {
+	// Client is created from the default AWS configuration when nil.
+	Client Client
+	// SchemaCompat keeps writing the secret without the schema field.
+	SchemaCompat bool
+	SecretName   string
+	// Verify tests a new token while it is staged as AWSPENDING, it is
+	// promoted to AWSCURRENT only if Verify succeeds. A token is unstaged
+	// when the error wraps shared.ErrTokenRejected, on other errors it stays
+	// staged and the next save of the same token promotes it.
+	Verify func(context.Context, shared.Token) error
+}
+
+const (
+	stageCurrent = "AWSCURRENT"
+	stagePending = "AWSPENDING"
+)
+
 type Client interface {
 	GetSecretValue(context.Context, *secretsmanager.GetSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
 	CreateSecret(context.Context, *secretsmanager.CreateSecretInput, ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error)
 	PutSecretValue(context.Context, *secretsmanager.PutSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error)
+	UpdateSecretVersionStage(context.Context, *secretsmanager.UpdateSecretVersionStageInput, ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error)
 }
 
 type Storage struct {
-	shared.GeneralStorage
-
 	client     Client
+	compat     bool
 	l          *log.Entry
 	name       string
 	secretName string
+	verify     func(context.Context, shared.Token) error
 }
 
-var notFound *types.ResourceNotFoundException
-
 func (s *Storage) StorageGetName() string {
 	return s.name
 }
 
-func (s *Storage) Read() error {
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	snapshot := shared.Snapshot{}
+
 	if res, err := s.client.GetSecretValue(
-		context.Background(),
+		ctx,
 		&secretsmanager.GetSecretValueInput{
 			SecretId: &s.secretName,
 		},
 	); err != nil {
+		var notFound *types.ResourceNotFoundException
 		if errors.As(err, &notFound) {
-			secretString := "{}"
-
-			if _, err := s.client.CreateSecret(
-				context.Background(),
-				&secretsmanager.CreateSecretInput{
-					Name:         &s.secretName,
-					SecretString: &secretString,
-				},
-			); err != nil {
-				return err
-			}
-
-			return fmt.Errorf("secret not fount")
+			return snapshot, fmt.Errorf("secret not fount: %w", shared.ErrNotFound)
 		}
 
-		return err
+		return snapshot, err
 	} else {
-		if err := json.Unmarshal([]byte(*res.SecretString), &s.Token); err != nil {
-			return err
+		if snapshot.Token, err = document.Unmarshal([]byte(aws.ToString(res.SecretString))); err != nil {
+			return snapshot, err
+		}
+
+		if res.VersionId != nil {
+			snapshot.Version = *res.VersionId
 		}
 	}
 
-	return nil
+	return snapshot, nil
 }
 
-func (s *Storage) Save() error {
-	data, err := json.Marshal(s.Token)
+// current returns the version holding AWSCURRENT, empty when the secret
+// doesn't exist.
+func (s *Storage) current(ctx context.Context) (string, bool, error) {
+	res, err := s.client.GetSecretValue(
+		ctx,
+		&secretsmanager.GetSecretValueInput{
+			SecretId: &s.secretName,
+		},
+	)
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return "", false, nil
+		}
+
+		return "", false, err
+	}
+
+	return aws.ToString(res.VersionId), true, nil
+}
+
+// Store stages the new value as AWSPENDING, verifies it and only then moves
+// AWSCURRENT from prevVersion to it, so readers never see an unverified
+// token. Secrets Manager keeps the replaced version as AWSPREVIOUS. Moving
+// AWSCURRENT fails if it isn't on prevVersion anymore, which is reported as
+// a version conflict. A missing secret is created with the verified value.
+// A verification that fails without a rejection from Slack keeps the value
+// staged, retrying the save promotes it without staging a new version.
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	current, exists, err := s.current(ctx)
+	if err != nil {
+		return err
+	}
+
+	if current != prevVersion {
+		return shared.ErrVersionConflict
+	}
+
+	data, err := document.Marshal(snapshot.Token, s.compat)
 	if err != nil {
 		return err
 	}
 
 	secretString := string(data)
 
-	if _, err := s.client.PutSecretValue(
-		context.Background(),
-		&secretsmanager.PutSecretValueInput{
-			SecretId:     &s.secretName,
-			SecretString: &secretString,
+	if !exists {
+		if err := s.verifyToken(ctx, snapshot.Token); err != nil {
+			return err
+		}
+
+		_, err := s.client.CreateSecret(
+			ctx,
+			&secretsmanager.CreateSecretInput{
+				Name:         &s.secretName,
+				SecretString: &secretString,
+			},
+		)
+
+		return err
+	}
+
+	pending, err := s.stage(ctx, secretString)
+	if err != nil {
+		return err
+	}
+
+	if err := s.verifyToken(ctx, snapshot.Token); err != nil {
+		if errors.Is(err, shared.ErrTokenRejected) {
+			s.unstage(ctx, pending)
+
+			return err
+		}
+
+		return fmt.Errorf("%w, the token stays staged as %s", err, stagePending)
+	}
+
+	if _, err := s.client.UpdateSecretVersionStage(
+		ctx,
+		&secretsmanager.UpdateSecretVersionStageInput{
+			SecretId:            &s.secretName,
+			VersionStage:        aws.String(stageCurrent),
+			MoveToVersionId:     &pending,
+			RemoveFromVersionId: &prevVersion,
 		},
 	); err != nil {
-		return err
+		s.unstage(ctx, pending)
+
+		var invalidParam *types.InvalidParameterException
+		if errors.As(err, &invalidParam) {
+			if current, _, cerr := s.current(ctx); cerr == nil && current != prevVersion {
+				return shared.ErrVersionConflict
+			}
+		}
+
+		return fmt.Errorf("failed to promote pending secret version: %w", err)
 	}
 
+	s.unstage(ctx, pending)
+
+	s.l.WithField("version", pending).Debug("pending secret version was promoted")
+
 	return nil
 }
 
-func NewClient() Client {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+// stage returns the version staged as AWSPENDING with the value, left by a
+// save whose verification failed, or stages a new one.
+func (s *Storage) stage(ctx context.Context, value string) (string, error) {
+	res, err := s.client.GetSecretValue(
+		ctx,
+		&secretsmanager.GetSecretValueInput{
+			SecretId:     &s.secretName,
+			VersionStage: aws.String(stagePending),
+		},
+	)
+	if err == nil && aws.ToString(res.SecretString) == value {
+		s.l.WithField("version", aws.ToString(res.VersionId)).Debug("secret version is already staged")
+
+		return aws.ToString(res.VersionId), nil
+	}
+
+	var notFound *types.ResourceNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return "", err
+	}
+
+	put, err := s.client.PutSecretValue(
+		ctx,
+		&secretsmanager.PutSecretValueInput{
+			SecretId:      &s.secretName,
+			SecretString:  &value,
+			VersionStages: []string{stagePending},
+		},
+	)
 	if err != nil {
-		log.WithField("err", err).Fatal("failed get config")
+		return "", err
 	}
 
-	return secretsmanager.NewFromConfig(cfg)
+	return aws.ToString(put.VersionId), nil
 }
 
-func New() *Storage {
-	viper.SetDefault("awssecrets.secret_name", shared.PkgName)
+// verifyToken runs Verify, tombstones of migrated tokens have no access
+// token to verify.
+func (s *Storage) verifyToken(ctx context.Context, token shared.Token) error {
+	if s.verify == nil || token.MovedTo != "" {
+		return nil
+	}
+
+	if err := s.verify(ctx, token); err != nil {
+		return fmt.Errorf("failed to verify pending token: %w", err)
+	}
+
+	return nil
+}
+
+// unstage removes AWSPENDING from a version once it was promoted or
+// rejected. Another writer may have moved the stage already, so failures
+// are only logged.
+func (s *Storage) unstage(ctx context.Context, version string) {
+	if _, err := s.client.UpdateSecretVersionStage(
+		ctx,
+		&secretsmanager.UpdateSecretVersionStageInput{
+			SecretId:            &s.secretName,
+			VersionStage:        aws.String(stagePending),
+			RemoveFromVersionId: &version,
+		},
+	); err != nil {
+		s.l.WithField("err", err).Debug("failed to remove pending stage")
+	}
+}
+
+func NewClient(ctx context.Context) (Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretsmanager.NewFromConfig(cfg), nil
+}
+
+func New(ctx context.Context, opts Options) (*Storage, error) {
+	c := opts.Client
+	if c == nil {
+		var err error
+		if c, err = NewClient(ctx); err != nil {
+			return nil, err
+		}
+	}
 
 	s := &Storage{
-		client:     NewClient(),
+		client:     c,
+		compat:     opts.SchemaCompat,
 		l:          log.WithField("storage", "awssecrets"),
 		name:       "awssecrets",
-		secretName: viper.GetString("awssecrets.secret_name"),
+		secretName: opts.SecretName,
+		verify:     opts.Verify,
 	}
 
-	return s
+	return s, nil
 }
@@ -2,11 +2,21 @@ package awssecrets
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
 )
 
 type ClientMock struct {
@@ -25,34 +35,75 @@ func (c *ClientMock) PutSecretValue(ctx context.Context, params *secretsmanager.
 	args := c.Called(ctx, params, []func(*secretsmanager.Options){})
 	return args.Get(0).(*secretsmanager.PutSecretValueOutput), args.Error(1)
 }
+func (c *ClientMock) UpdateSecretVersionStage(ctx context.Context, params *secretsmanager.UpdateSecretVersionStageInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	args := c.Called(ctx, params, []func(*secretsmanager.Options){})
+	return args.Get(0).(*secretsmanager.UpdateSecretVersionStageOutput), args.Error(1)
+}
 
 func TestStorage(t *testing.T) {
 	tests := []struct {
 		name         string
 		secretName   string
 		secretString string
-		prepare      func(*ClientMock, string, string)
+		stored       string
+		prepare      func(*ClientMock, string, string, string)
 	}{
 		{
 			name:         "reading the secret",
 			secretName:   "test-secret-name",
 			secretString: `{"access_token":"access-token","exp":0,"refresh_token":"refresh-token"}`,
-			prepare: func(c *ClientMock, secretName, secretString string) {
+			stored:       `{"schema":2,"access_token":"access-token","exp":0,"refresh_token":"refresh-token"}`,
+			prepare: func(c *ClientMock, secretName, secretString, stored string) {
 				c.On(
 					"GetSecretValue",
 					context.Background(),
 					&secretsmanager.GetSecretValueInput{SecretId: &secretName},
 					[]func(*secretsmanager.Options){},
-				).Return(&secretsmanager.GetSecretValueOutput{SecretString: &secretString}, nil)
+				).Return(&secretsmanager.GetSecretValueOutput{SecretString: &secretString, VersionId: aws.String("version-1")}, nil)
+
+				c.On(
+					"GetSecretValue",
+					context.Background(),
+					&secretsmanager.GetSecretValueInput{SecretId: &secretName, VersionStage: aws.String("AWSPENDING")},
+					[]func(*secretsmanager.Options){},
+				).Return(&secretsmanager.GetSecretValueOutput{}, &types.ResourceNotFoundException{})
+
+				c.On(
+					"PutSecretValue",
+					context.Background(),
+					&secretsmanager.PutSecretValueInput{SecretId: &secretName, SecretString: &stored, VersionStages: []string{"AWSPENDING"}},
+					[]func(*secretsmanager.Options){},
+				).Return(&secretsmanager.PutSecretValueOutput{VersionId: aws.String("version-2")}, nil)
+
+				c.On(
+					"UpdateSecretVersionStage",
+					context.Background(),
+					&secretsmanager.UpdateSecretVersionStageInput{
+						SecretId:            &secretName,
+						VersionStage:        aws.String("AWSCURRENT"),
+						MoveToVersionId:     aws.String("version-2"),
+						RemoveFromVersionId: aws.String("version-1"),
+					},
+					[]func(*secretsmanager.Options){},
+				).Return(&secretsmanager.UpdateSecretVersionStageOutput{}, nil)
+
+				c.On(
+					"UpdateSecretVersionStage",
+					context.Background(),
+					&secretsmanager.UpdateSecretVersionStageInput{
+						SecretId:            &secretName,
+						VersionStage:        aws.String("AWSPENDING"),
+						RemoveFromVersionId: aws.String("version-2"),
+					},
+					[]func(*secretsmanager.Options){},
+				).Return(&secretsmanager.UpdateSecretVersionStageOutput{}, nil)
 			},
 		},
 		{
-			name:         "creating the secret",
-			secretName:   "test-secret-name",
-			secretString: `{"access_token":"","exp":0,"refresh_token":""}`,
-			prepare: func(c *ClientMock, secretName, secretString string) {
-				emptySecret := "{}"
-
+			name:       "creating the secret",
+			secretName: "test-secret-name",
+			stored:     `{"schema":2,"access_token":"","exp":0,"refresh_token":""}`,
+			prepare: func(c *ClientMock, secretName, secretString, stored string) {
 				c.On(
 					"GetSecretValue",
 					context.Background(),
@@ -63,7 +114,7 @@ func TestStorage(t *testing.T) {
 				c.On(
 					"CreateSecret",
 					context.Background(),
-					&secretsmanager.CreateSecretInput{Name: &secretName, SecretString: &emptySecret},
+					&secretsmanager.CreateSecretInput{Name: &secretName, SecretString: &stored},
 					[]func(*secretsmanager.Options){},
 				).Return(&secretsmanager.CreateSecretOutput{}, nil)
 			},
@@ -77,24 +128,229 @@ func TestStorage(t *testing.T) {
 			s := &Storage{
 				name:       "test",
 				client:     c,
+				l:          log.WithField("storage", "awssecrets"),
 				secretName: tt.secretName,
 			}
 
 			if tt.prepare != nil {
-				tt.prepare(c, tt.secretName, tt.secretString)
+				tt.prepare(c, tt.secretName, tt.secretString, tt.stored)
 			}
 
-			c.On(
-				"PutSecretValue",
-				context.Background(),
-				&secretsmanager.PutSecretValueInput{SecretId: &tt.secretName, SecretString: &tt.secretString},
-				[]func(*secretsmanager.Options){},
-			).Return(&secretsmanager.PutSecretValueOutput{}, nil)
-
 			s.StorageGetName()
-			s.Read()
-			s.Save()
+			snapshot, _ := s.Load(context.Background())
+			assert.NoError(t, s.Store(context.Background(), snapshot, snapshot.Version))
 			c.AssertExpectations(t)
 		})
 	}
 }
+
+// memSecrets is an in-memory Secrets Manager holding one secret with its
+// versions and version stages.
+type memSecrets struct {
+	mu       sync.Mutex
+	versions map[string]string
+	stages   map[string]string
+	next     int
+}
+
+func newMemSecrets() *memSecrets {
+	return &memSecrets{versions: map[string]string{}, stages: map[string]string{}}
+}
+
+func (m *memSecrets) add(value string) string {
+	m.next++
+	id := fmt.Sprintf("version-%d", m.next)
+	m.versions[id] = value
+
+	return id
+}
+
+// move attaches stage to the version, the replaced AWSCURRENT becomes
+// AWSPREVIOUS.
+func (m *memSecrets) move(stage, id string) {
+	if prev, ok := m.stages[stage]; ok && stage == "AWSCURRENT" && prev != id {
+		m.stages["AWSPREVIOUS"] = prev
+	}
+
+	m.stages[stage] = id
+}
+
+func (m *memSecrets) GetSecretValue(_ context.Context, params *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stage := aws.ToString(params.VersionStage)
+	if stage == "" {
+		stage = "AWSCURRENT"
+	}
+
+	id, ok := m.stages[stage]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+
+	value := m.versions[id]
+
+	return &secretsmanager.GetSecretValueOutput{SecretString: &value, VersionId: &id}, nil
+}
+func (m *memSecrets) CreateSecret(_ context.Context, params *secretsmanager.CreateSecretInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.versions) > 0 {
+		return nil, &types.ResourceExistsException{}
+	}
+
+	id := m.add(*params.SecretString)
+	m.move("AWSCURRENT", id)
+
+	return &secretsmanager.CreateSecretOutput{VersionId: &id}, nil
+}
+func (m *memSecrets) PutSecretValue(_ context.Context, params *secretsmanager.PutSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.versions) == 0 {
+		return nil, &types.ResourceNotFoundException{}
+	}
+
+	stages := params.VersionStages
+	if len(stages) == 0 {
+		stages = []string{"AWSCURRENT"}
+	}
+
+	id := m.add(*params.SecretString)
+	for _, stage := range stages {
+		m.move(stage, id)
+	}
+
+	return &secretsmanager.PutSecretValueOutput{VersionId: &id, VersionStages: stages}, nil
+}
+func (m *memSecrets) UpdateSecretVersionStage(_ context.Context, params *secretsmanager.UpdateSecretVersionStageInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stage := aws.ToString(params.VersionStage)
+	attached, ok := m.stages[stage]
+
+	// the stage can only be moved away from the version holding it
+	if ok && attached != aws.ToString(params.RemoveFromVersionId) {
+		return nil, &types.InvalidParameterException{}
+	}
+
+	if params.MoveToVersionId == nil {
+		delete(m.stages, stage)
+
+		return &secretsmanager.UpdateSecretVersionStageOutput{}, nil
+	}
+
+	if _, ok := m.versions[*params.MoveToVersionId]; !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+
+	m.move(stage, *params.MoveToVersionId)
+
+	return &secretsmanager.UpdateSecretVersionStageOutput{}, nil
+}
+
+func newStorage(m *memSecrets, verify func(context.Context, shared.Token) error) *Storage {
+	return &Storage{
+		client:     m,
+		l:          log.WithField("storage", "awssecrets"),
+		name:       "awssecrets",
+		secretName: "tokens-rotate",
+		verify:     verify,
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		m := newMemSecrets()
+
+		return storagetest.Backend{
+			Storage: newStorage(m, nil),
+			Corrupt: func(t *testing.T) {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+
+				m.versions[m.stages["AWSCURRENT"]] = "{not json"
+			},
+		}
+	})
+}
+
+func TestStagedRotation(t *testing.T) {
+	ctx := context.Background()
+	m := newMemSecrets()
+	errUnavailable := errors.New("slack is unavailable")
+	unavailable := false
+
+	verified := []shared.Token{}
+	s := newStorage(m, func(_ context.Context, token shared.Token) error {
+		verified = append(verified, token)
+
+		// the token is staged while it is verified
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if _, ok := m.stages["AWSPENDING"]; !ok && len(m.versions) > 0 {
+			return errors.New("token is not staged")
+		}
+
+		if token.AccessToken == "xoxe.xoxp-1-bad" {
+			return fmt.Errorf("%w: invalid_auth", shared.ErrTokenRejected)
+		}
+
+		if unavailable {
+			return errUnavailable
+		}
+
+		return nil
+	})
+
+	first := shared.Token{AccessToken: "xoxe.xoxp-1-first", Exp: 1685800000, RefreshToken: "xoxe-1-first"}
+	require.NoError(t, s.Store(ctx, shared.Snapshot{Token: first}, ""))
+
+	prev, err := s.Load(ctx)
+	require.NoError(t, err)
+
+	// a rejected token is never current and isn't left pending
+	bad := shared.Token{AccessToken: "xoxe.xoxp-1-bad", Exp: 1685843200, RefreshToken: "xoxe-1-bad"}
+	assert.ErrorIs(t, s.Store(ctx, shared.Snapshot{Token: bad}, prev.Version), shared.ErrTokenRejected)
+
+	snapshot, err := s.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, first, snapshot.Token)
+	assert.Equal(t, prev.Version, snapshot.Version)
+	assert.NotContains(t, m.stages, "AWSPENDING")
+
+	// a token that couldn't be verified stays pending for the next save
+	second := shared.Token{AccessToken: "xoxe.xoxp-1-second", Exp: 1685843200, RefreshToken: "xoxe-1-second"}
+	unavailable = true
+	assert.ErrorIs(t, s.Store(ctx, shared.Snapshot{Token: second}, prev.Version), errUnavailable)
+
+	snapshot, err = s.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, first, snapshot.Token)
+
+	pending := m.stages["AWSPENDING"]
+	assert.NotEmpty(t, pending)
+
+	// the next save promotes the staged version and replaces the current one
+	unavailable = false
+	require.NoError(t, s.Store(ctx, shared.Snapshot{Token: second}, prev.Version))
+
+	snapshot, err = s.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, second, snapshot.Token)
+	assert.Equal(t, pending, snapshot.Version)
+	assert.Equal(t, prev.Version, m.stages["AWSPREVIOUS"])
+	assert.NotContains(t, m.stages, "AWSPENDING")
+
+	assert.Equal(t, []shared.Token{first, bad, second, second}, verified)
+
+	// tombstones are stored without verifying
+	tombstone := shared.Token{Exp: 1685843200, MovedTo: "vault"}
+	require.NoError(t, s.Store(ctx, shared.Snapshot{Token: tombstone}, snapshot.Version))
+	assert.Len(t, verified, 4)
+}
@@ -0,0 +1,288 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/slack-utils/tokens-rotate/internal/document"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+type Options struct {
+	// Client is created from the CONSUL_* environment variables when nil.
+	Client *api.Client
+	// Key holding the token document.
+	Key string
+	// LockKey is acquired by the session of the rotating daemon, Key with
+	// the /lock suffix by default.
+	LockKey string
+	// SessionTTL of the lock session, 15s by default.
+	SessionTTL time.Duration
+}
+
+type KV interface {
+	Get(string, *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	CAS(*api.KVPair, *api.WriteOptions) (bool, *api.WriteMeta, error)
+	Acquire(*api.KVPair, *api.WriteOptions) (bool, *api.WriteMeta, error)
+	Release(*api.KVPair, *api.WriteOptions) (bool, *api.WriteMeta, error)
+	Txn(api.KVTxnOps, *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error)
+}
+
+type Sessions interface {
+	Create(*api.SessionEntry, *api.WriteOptions) (string, *api.WriteMeta, error)
+	Destroy(string, *api.WriteOptions) (*api.WriteMeta, error)
+	RenewPeriodic(string, string, *api.WriteOptions, <-chan struct{}) error
+}
+
+type Storage struct {
+	kv       KV
+	sessions Sessions
+
+	// mu guards the lock session, it is renewed in the background until
+	// done is closed. Once Lock was called, stores require the lock until
+	// Unlock.
+	mu      sync.Mutex
+	session string
+	done    chan struct{}
+	locked  bool
+
+	key     string
+	l       *log.Entry
+	lockKey string
+	name    string
+	ttl     time.Duration
+}
+
+func (s *Storage) StorageGetName() string {
+	return s.name
+}
+
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	snapshot := shared.Snapshot{}
+
+	pair, _, err := s.kv.Get(s.key, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	if pair == nil {
+		return snapshot, fmt.Errorf("%w: %s", shared.ErrNotFound, s.key)
+	}
+
+	if snapshot.Token, err = document.Unmarshal(pair.Value); err != nil {
+		return snapshot, err
+	}
+
+	snapshot.Version = strconv.FormatUint(pair.ModifyIndex, 10)
+
+	return snapshot, nil
+}
+
+// Store uses the check-and-set of the KV store, so the key is written only
+// if its ModifyIndex is still prevVersion. Zero, an empty prevVersion,
+// writes the key only if it doesn't exist. A daemon taking part in the
+// locking also checks in the same transaction that its session holds the
+// lock key, a write of a daemon that lost it fails with ErrNotLeader.
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	index := uint64(0)
+	if prevVersion != "" {
+		var err error
+		if index, err = strconv.ParseUint(prevVersion, 10, 64); err != nil {
+			return fmt.Errorf("invalid key version: %w", err)
+		}
+	}
+
+	data, err := document.Marshal(snapshot.Token, false)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	locked, session := s.locked, s.session
+	s.mu.Unlock()
+
+	if !locked {
+		ok, _, err := s.kv.CAS(
+			&api.KVPair{Key: s.key, Value: data, ModifyIndex: index},
+			(&api.WriteOptions{}).WithContext(ctx),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save key: %w", err)
+		}
+
+		if !ok {
+			return shared.ErrVersionConflict
+		}
+
+		return nil
+	}
+
+	if session == "" {
+		return shared.ErrNotLeader
+	}
+
+	ok, res, _, err := s.kv.Txn(api.KVTxnOps{
+		{Verb: api.KVCheckSession, Key: s.lockKey, Session: session},
+		{Verb: api.KVCAS, Key: s.key, Value: data, Index: index},
+	}, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+
+	if ok {
+		return nil
+	}
+
+	for _, e := range res.Errors {
+		if e.OpIndex == 0 {
+			return shared.ErrNotLeader
+		}
+	}
+
+	return shared.ErrVersionConflict
+}
+
+// Lock acquires the lock key with the session of this process, creating
+// the session first. Acquiring a lock held by the own session succeeds, so
+// the lock is kept while the session is renewed.
+func (s *Storage) Lock(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locked = true
+
+	if s.session == "" {
+		if err := s.createSession(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	ok, _, err := s.kv.Acquire(
+		&api.KVPair{Key: s.lockKey, Session: s.session},
+		(&api.WriteOptions{}).WithContext(ctx),
+	)
+	if err != nil {
+		// the session may have expired, a new one is created next time
+		s.stopSession()
+
+		return false, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	return ok, nil
+}
+
+// Unlock releases the lock key and destroys the session.
+func (s *Storage) Unlock(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locked = false
+
+	if s.session == "" {
+		return nil
+	}
+
+	session := s.session
+	s.stopSession()
+
+	if _, _, err := s.kv.Release(
+		&api.KVPair{Key: s.lockKey, Session: session},
+		(&api.WriteOptions{}).WithContext(ctx),
+	); err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+
+	if _, err := s.sessions.Destroy(session, (&api.WriteOptions{}).WithContext(ctx)); err != nil {
+		return fmt.Errorf("failed to destroy session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Storage) createSession(ctx context.Context) error {
+	id, _, err := s.sessions.Create(
+		&api.SessionEntry{
+			Name:     shared.PkgName,
+			Behavior: api.SessionBehaviorRelease,
+			TTL:      s.ttl.String(),
+		},
+		(&api.WriteOptions{}).WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	s.session = id
+	s.done = make(chan struct{})
+
+	go s.renew(id, s.done)
+
+	return nil
+}
+
+func (s *Storage) renew(id string, done chan struct{}) {
+	err := s.sessions.RenewPeriodic(s.ttl.String(), id, nil, done)
+	if err == nil {
+		return
+	}
+
+	s.l.WithField("err", err).Warn("lock session was lost")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.session == id {
+		s.stopSession()
+	}
+}
+
+func (s *Storage) stopSession() {
+	close(s.done)
+	s.session = ""
+}
+
+func NewClient() (*api.Client, error) {
+	return api.NewClient(api.DefaultConfig())
+}
+
+func New(opts Options) (*Storage, error) {
+	if opts.Key == "" {
+		return nil, errors.New("consul key is not set")
+	}
+
+	if opts.LockKey == "" {
+		opts.LockKey = opts.Key + "/lock"
+	}
+
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = 15 * time.Second
+	}
+
+	c := opts.Client
+	if c == nil {
+		var err error
+		if c, err = NewClient(); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Storage{
+		kv:       c.KV(),
+		sessions: c.Session(),
+
+		key:     opts.Key,
+		l:       log.WithField("storage", "consul"),
+		lockKey: opts.LockKey,
+		name:    "consul",
+		ttl:     opts.SessionTTL,
+	}
+
+	return s, nil
+}
@@ -0,0 +1,305 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
+)
+
+// memConsul is an in-memory KV store with check-and-set and session locks.
+type memConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	pairs    map[string]*api.KVPair
+	sessions map[string]bool
+}
+
+func newMemConsul() *memConsul {
+	return &memConsul{
+		pairs:    map[string]*api.KVPair{},
+		sessions: map[string]bool{},
+	}
+}
+
+func (m *memConsul) Get(key string, _ *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pair, ok := m.pairs[key]
+	if !ok {
+		return nil, &api.QueryMeta{}, nil
+	}
+
+	p := *pair
+
+	return &p, &api.QueryMeta{}, nil
+}
+
+func (m *memConsul) CAS(p *api.KVPair, _ *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := uint64(0)
+	if pair, ok := m.pairs[p.Key]; ok {
+		current = pair.ModifyIndex
+	}
+
+	if current != p.ModifyIndex {
+		return false, &api.WriteMeta{}, nil
+	}
+
+	m.index++
+	m.pairs[p.Key] = &api.KVPair{Key: p.Key, Value: p.Value, ModifyIndex: m.index}
+
+	return true, &api.WriteMeta{}, nil
+}
+
+func (m *memConsul) Acquire(p *api.KVPair, _ *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.sessions[p.Session] {
+		return false, nil, fmt.Errorf("invalid session %q", p.Session)
+	}
+
+	if pair, ok := m.pairs[p.Key]; ok && pair.Session != "" && pair.Session != p.Session {
+		return false, &api.WriteMeta{}, nil
+	}
+
+	m.index++
+	m.pairs[p.Key] = &api.KVPair{Key: p.Key, Session: p.Session, ModifyIndex: m.index}
+
+	return true, &api.WriteMeta{}, nil
+}
+
+func (m *memConsul) Release(p *api.KVPair, _ *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pair, ok := m.pairs[p.Key]
+	if !ok || pair.Session != p.Session {
+		return false, &api.WriteMeta{}, nil
+	}
+
+	pair.Session = ""
+
+	return true, &api.WriteMeta{}, nil
+}
+
+// Txn supports the check-session and cas operations, it fails with the
+// index of the first failed operation.
+func (m *memConsul) Txn(ops api.KVTxnOps, _ *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failed := func(i int, what string) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+		return false, &api.KVTxnResponse{Errors: api.TxnErrors{{OpIndex: i, What: what}}}, &api.QueryMeta{}, nil
+	}
+
+	for i, op := range ops {
+		pair, ok := m.pairs[op.Key]
+
+		switch op.Verb {
+		case api.KVCheckSession:
+			if !ok || pair.Session != op.Session {
+				return failed(i, "key is not locked by the session")
+			}
+		case api.KVCAS:
+			if ok && pair.ModifyIndex != op.Index || !ok && op.Index != 0 {
+				return failed(i, "index is stale")
+			}
+		default:
+			return false, nil, nil, fmt.Errorf("unsupported operation %q", op.Verb)
+		}
+	}
+
+	for _, op := range ops {
+		if op.Verb == api.KVCAS {
+			m.index++
+			m.pairs[op.Key] = &api.KVPair{Key: op.Key, Value: op.Value, ModifyIndex: m.index}
+		}
+	}
+
+	return true, &api.KVTxnResponse{}, &api.QueryMeta{}, nil
+}
+
+func (m *memConsul) Create(_ *api.SessionEntry, _ *api.WriteOptions) (string, *api.WriteMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.index++
+	id := fmt.Sprintf("session-%d", m.index)
+	m.sessions[id] = true
+
+	return id, &api.WriteMeta{}, nil
+}
+
+func (m *memConsul) Destroy(id string, _ *api.WriteOptions) (*api.WriteMeta, error) {
+	m.expire(id)
+
+	return &api.WriteMeta{}, nil
+}
+
+func (m *memConsul) RenewPeriodic(_ string, id string, _ *api.WriteOptions, done <-chan struct{}) error {
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-time.After(time.Millisecond):
+			m.mu.Lock()
+			ok := m.sessions[id]
+			m.mu.Unlock()
+
+			if !ok {
+				return errors.New("session expired")
+			}
+		}
+	}
+}
+
+// expire invalidates a session and releases its locks.
+func (m *memConsul) expire(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+
+	for _, pair := range m.pairs {
+		if pair.Session == id {
+			pair.Session = ""
+		}
+	}
+}
+
+func newStorage(m *memConsul) *Storage {
+	return &Storage{
+		kv:       m,
+		sessions: m,
+
+		key:     "tokens-rotate",
+		l:       log.WithField("storage", "consul"),
+		lockKey: "tokens-rotate/lock",
+		name:    "consul",
+		ttl:     time.Second,
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		m := newMemConsul()
+
+		return storagetest.Backend{
+			Storage: newStorage(m),
+			Corrupt: func(t *testing.T) {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+
+				m.pairs["tokens-rotate"].Value = []byte(`{"schema":2,"exp":"soon"}`)
+			},
+		}
+	})
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	m := newMemConsul()
+	first, second := newStorage(m), newStorage(m)
+
+	ok, err := first.Lock(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// the lock is kept by its session and refused to others
+	ok, err = first.Lock(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = second.Lock(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, first.Unlock(ctx))
+
+	ok, err = second.Lock(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// an expired session loses the lock, a new session is created
+	m.expire(second.session)
+
+	assert.Eventually(t, func() bool {
+		second.mu.Lock()
+		defer second.mu.Unlock()
+
+		return second.session == ""
+	}, time.Second, time.Millisecond)
+
+	ok, err = first.Lock(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = second.Lock(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, first.Unlock(ctx))
+	require.NoError(t, second.Unlock(ctx))
+}
+
+// unrenewed doesn't notice expired sessions.
+type unrenewed struct {
+	*memConsul
+}
+
+func (unrenewed) RenewPeriodic(_ string, _ string, _ *api.WriteOptions, done <-chan struct{}) error {
+	<-done
+	return nil
+}
+
+func TestStoreLeadership(t *testing.T) {
+	ctx := context.Background()
+	m := newMemConsul()
+	first, second := newStorage(m), newStorage(m)
+	first.sessions = unrenewed{m}
+
+	token := shared.Token{AccessToken: "xoxe.xoxp-1", Exp: 1685800000, RefreshToken: "xoxe-1"}
+
+	ok, err := first.Lock(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, first.Store(ctx, shared.Snapshot{Token: token}, ""))
+
+	stored, err := first.Load(ctx)
+	require.NoError(t, err)
+
+	// a daemon waiting for the lock doesn't write
+	ok, err = second.Lock(ctx)
+	require.NoError(t, err)
+	require.False(t, ok)
+	assert.ErrorIs(t, second.Store(ctx, shared.Snapshot{Token: token}, stored.Version), shared.ErrNotLeader)
+
+	// neither does a daemon whose session expired before it noticed
+	m.expire(first.session)
+	assert.ErrorIs(t, first.Store(ctx, shared.Snapshot{Token: token}, stored.Version), shared.ErrNotLeader)
+
+	// the new leader writes, a conflict is still reported as such
+	ok, err = second.Lock(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.ErrorIs(t, second.Store(ctx, shared.Snapshot{Token: token}, ""), shared.ErrVersionConflict)
+	require.NoError(t, second.Store(ctx, shared.Snapshot{Token: token}, stored.Version))
+
+	require.NoError(t, first.Unlock(ctx))
+	require.NoError(t, second.Unlock(ctx))
+}
@@ -0,0 +1,523 @@
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/slack-utils/tokens-rotate/internal/document"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+// watchRetry is the delay before a closed watch is created again or the
+// watched key is read again after a failed read.
+const watchRetry = time.Second
+
+type Options struct {
+	// Client is created from the options below when nil.
+	Client      *clientv3.Client
+	Endpoints   []string
+	DialTimeout time.Duration
+	Username    string
+	Password    string
+	// CAFile, CertFile and KeyFile enable TLS.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// Key holding the token document.
+	Key string
+	// ElectionPrefix of the rotating daemon election, Key with the
+	// /election suffix by default.
+	ElectionPrefix string
+	// SessionTTL of the election lease, 15s by default.
+	SessionTTL time.Duration
+}
+
+// Election elects the rotating daemon, it is backed by the concurrency
+// package of the etcd client.
+type Election interface {
+	Campaign(ctx context.Context, val string) error
+	Resign(ctx context.Context) error
+	// Done is closed when the lease of the election expires.
+	Done() <-chan struct{}
+	Close() error
+	// Key and Rev identify the campaign key of this process, which exists
+	// with Rev as its create revision while the process is the leader.
+	Key() string
+	Rev() int64
+}
+
+type session struct {
+	*concurrency.Election
+	*concurrency.Session
+}
+
+type Storage struct {
+	kv          clientv3.KV
+	watcher     clientv3.Watcher
+	newElection func() (Election, error)
+
+	// mu guards the election, the campaign runs in the background until
+	// cancel is called. Once Lock was called, stores require the
+	// leadership until Unlock.
+	mu       sync.Mutex
+	election Election
+	cancel   context.CancelFunc
+	leader   bool
+	locked   bool
+
+	key  string
+	l    *log.Entry
+	name string
+}
+
+func (s *Storage) StorageGetName() string {
+	return s.name
+}
+
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	snapshot := shared.Snapshot{}
+
+	res, err := s.kv.Get(ctx, s.key)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	if len(res.Kvs) == 0 {
+		return snapshot, fmt.Errorf("%w: %s", shared.ErrNotFound, s.key)
+	}
+
+	if snapshot.Token, err = document.Unmarshal(res.Kvs[0].Value); err != nil {
+		return snapshot, err
+	}
+
+	snapshot.Version = strconv.FormatInt(res.Kvs[0].ModRevision, 10)
+
+	return snapshot, nil
+}
+
+// Store writes the key in a transaction comparing its mod revision with
+// prevVersion, an empty prevVersion requires the key to be absent. A daemon
+// taking part in the election also compares its campaign key, so a write
+// of a daemon that lost the leadership fails with ErrNotLeader.
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	cmp := clientv3.Compare(clientv3.CreateRevision(s.key), "=", 0)
+
+	if prevVersion != "" {
+		rev, err := strconv.ParseInt(prevVersion, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key version: %w", err)
+		}
+
+		cmp = clientv3.Compare(clientv3.ModRevision(s.key), "=", rev)
+	}
+
+	data, err := document.Marshal(snapshot.Token, false)
+	if err != nil {
+		return err
+	}
+
+	leader, err := s.leaderCmp()
+	if err != nil {
+		return err
+	}
+
+	put := clientv3.OpPut(s.key, string(data))
+
+	if leader == nil {
+		res, err := s.kv.Txn(ctx).If(cmp).Then(put).Commit()
+		if err != nil {
+			return fmt.Errorf("failed to save key: %w", err)
+		}
+
+		if !res.Succeeded {
+			return shared.ErrVersionConflict
+		}
+
+		return nil
+	}
+
+	res, err := s.kv.Txn(ctx).
+		If(*leader).
+		Then(clientv3.OpTxn([]clientv3.Cmp{cmp}, []clientv3.Op{put}, nil)).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+
+	if !res.Succeeded {
+		return shared.ErrNotLeader
+	}
+
+	if !res.Responses[0].GetResponseTxn().Succeeded {
+		return shared.ErrVersionConflict
+	}
+
+	return nil
+}
+
+// leaderCmp returns the comparison holding while this process leads the
+// election, nil when Lock wasn't called.
+func (s *Storage) leaderCmp() (*clientv3.Cmp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.locked {
+		return nil, nil
+	}
+
+	if !s.leader {
+		return nil, shared.ErrNotLeader
+	}
+
+	cmp := clientv3.Compare(clientv3.CreateRevision(s.election.Key()), "=", s.election.Rev())
+
+	return &cmp, nil
+}
+
+// Watch sends the token every time the key is written. The watch is
+// created again from the last seen revision when etcd closes it, and
+// after a fresh read of the key when that revision was compacted.
+func (s *Storage) Watch(ctx context.Context) <-chan shared.Snapshot {
+	ch := make(chan shared.Snapshot)
+
+	go func() {
+		defer close(ch)
+
+		send := func(snapshot shared.Snapshot) bool {
+			select {
+			case ch <- snapshot:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// rev is the last revision the watch has seen, zero until the
+		// first watch was created
+		rev := int64(0)
+
+		for {
+			opts := []clientv3.OpOption{clientv3.WithCreatedNotify()}
+			if rev > 0 {
+				opts = append(opts, clientv3.WithRev(rev+1))
+			}
+
+			compacted := false
+
+			for res := range s.watcher.Watch(ctx, s.key, opts...) {
+				if res.CompactRevision > 0 {
+					compacted = true
+
+					break
+				}
+
+				if err := res.Err(); err != nil {
+					s.l.WithField("err", err).Warn("watching key was failed")
+
+					continue
+				}
+
+				// the first watch starts at the revision it was created at,
+				// later ones only move on with the events they send
+				if res.Created && rev == 0 {
+					rev = res.Header.Revision
+				}
+
+				for _, e := range res.Events {
+					rev = e.Kv.ModRevision
+
+					if e.Type != clientv3.EventTypePut {
+						continue
+					}
+
+					token, err := document.Unmarshal(e.Kv.Value)
+					if err != nil {
+						s.l.WithField("err", err).Error("failed to decode watched token")
+
+						continue
+					}
+
+					if !send(shared.Snapshot{Token: token, Version: strconv.FormatInt(e.Kv.ModRevision, 10)}) {
+						return
+					}
+				}
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if !compacted {
+				s.l.WithField("revision", rev).Warn("watch was closed, watching again")
+
+				select {
+				case <-time.After(watchRetry):
+				case <-ctx.Done():
+					return
+				}
+
+				continue
+			}
+
+			s.l.WithField("revision", rev).Warn("watched revision was compacted, reading the key again")
+
+			for {
+				snapshot, latest, err := s.reload(ctx, rev)
+				if err == nil {
+					if snapshot != nil && !send(*snapshot) {
+						return
+					}
+					rev = latest
+
+					break
+				}
+
+				s.l.WithField("err", err).Warn("failed to read the watched key")
+
+				select {
+				case <-time.After(watchRetry):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// reload reads the key after the watch lost its revision. It returns the
+// stored token when it was written after rev, and the revision to watch
+// from.
+func (s *Storage) reload(ctx context.Context, rev int64) (*shared.Snapshot, int64, error) {
+	res, err := s.kv.Get(ctx, s.key)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	if len(res.Kvs) == 0 || res.Kvs[0].ModRevision <= rev {
+		return nil, res.Header.Revision, nil
+	}
+
+	token, err := document.Unmarshal(res.Kvs[0].Value)
+	if err != nil {
+		// a token that can't be decoded is skipped as it is when watched
+		s.l.WithField("err", err).Error("failed to decode watched token")
+
+		return nil, res.Header.Revision, nil
+	}
+
+	snapshot := &shared.Snapshot{Token: token, Version: strconv.FormatInt(res.Kvs[0].ModRevision, 10)}
+
+	return snapshot, res.Header.Revision, nil
+}
+
+// Lock joins the election on the first call and reports whether this
+// process was elected. The campaign waits in the background, so a later
+// call returns true once the previous leader has resigned or its lease
+// expired.
+func (s *Storage) Lock(_ context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locked = true
+
+	if s.election == nil {
+		e, err := s.newElection()
+		if err != nil {
+			return false, fmt.Errorf("failed to create election session: %w", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		s.election = e
+		s.cancel = cancel
+		s.leader = false
+
+		go s.campaign(ctx, e)
+	}
+
+	return s.leader, nil
+}
+
+// Unlock resigns the leadership and leaves the election.
+func (s *Storage) Unlock(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locked = false
+
+	if s.election == nil {
+		return nil
+	}
+
+	var err error
+	if s.leader {
+		if err = s.election.Resign(ctx); err != nil {
+			err = fmt.Errorf("failed to resign: %w", err)
+		}
+	}
+
+	s.reset()
+
+	return err
+}
+
+func (s *Storage) campaign(ctx context.Context, e Election) {
+	go func() {
+		select {
+		case <-e.Done():
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if s.election == e {
+				s.l.Warn("election lease was lost")
+				s.reset()
+			}
+		case <-ctx.Done():
+		}
+	}()
+
+	err := e.Campaign(ctx, campaignValue())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.election != e {
+		return
+	}
+
+	if err != nil {
+		s.l.WithField("err", err).Warn("election campaign was failed")
+		s.reset()
+
+		return
+	}
+
+	s.l.Info("elected to rotate the token")
+	s.leader = true
+}
+
+func (s *Storage) reset() {
+	s.cancel()
+
+	if err := s.election.Close(); err != nil {
+		s.l.WithField("err", err).Warn("failed to close election session")
+	}
+
+	s.election = nil
+	s.leader = false
+}
+
+// campaignValue identifies the daemon in the election key.
+func campaignValue() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	if opts.CAFile == "" && opts.CertFile == "" {
+		return nil, nil
+	}
+
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if opts.CAFile != "" {
+		data, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", opts.CAFile)
+		}
+	}
+
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
+
+func NewClient(opts Options) (*clientv3.Client, error) {
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure tls: %w", err)
+	}
+
+	return clientv3.New(clientv3.Config{
+		DialTimeout: opts.DialTimeout,
+		Endpoints:   opts.Endpoints,
+		Password:    opts.Password,
+		TLS:         tlsConfig,
+		Username:    opts.Username,
+	})
+}
+
+func New(opts Options) (*Storage, error) {
+	if opts.Key == "" {
+		return nil, errors.New("etcd key is not set")
+	}
+
+	if opts.ElectionPrefix == "" {
+		opts.ElectionPrefix = opts.Key + "/election"
+	}
+
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = 15 * time.Second
+	}
+
+	c := opts.Client
+	if c == nil {
+		var err error
+		if c, err = NewClient(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Storage{
+		kv:      c,
+		watcher: c,
+		newElection: func() (Election, error) {
+			ses, err := concurrency.NewSession(c, concurrency.WithTTL(int(opts.SessionTTL.Seconds())))
+			if err != nil {
+				return nil, err
+			}
+
+			return session{
+				Election: concurrency.NewElection(ses, opts.ElectionPrefix),
+				Session:  ses,
+			}, nil
+		},
+
+		key:  opts.Key,
+		l:    log.WithField("storage", "etcd"),
+		name: "etcd",
+	}
+
+	return s, nil
+}
@@ -0,0 +1,319 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
+)
+
+// newEtcd starts a single member etcd server and returns a client of it.
+func newEtcd(t *testing.T) *clientv3.Client {
+	t.Helper()
+
+	localhost, err := url.Parse("http://127.0.0.1:0")
+	require.NoError(t, err)
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	cfg.ListenClientUrls = []url.URL{*localhost}
+	cfg.AdvertiseClientUrls = []url.URL{*localhost}
+	cfg.ListenPeerUrls = []url.URL{*localhost}
+	cfg.AdvertisePeerUrls = []url.URL{*localhost}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd server was not started")
+	}
+
+	c, err := clientv3.New(clientv3.Config{
+		DialTimeout: 5 * time.Second,
+		Endpoints:   []string{e.Clients[0].Addr().String()},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func newStorage(t *testing.T, c *clientv3.Client, key string) *Storage {
+	t.Helper()
+
+	s, err := New(Options{Client: c, Key: key, SessionTTL: time.Second})
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	c := newEtcd(t)
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		key := "tokens-rotate/" + strings.ReplaceAll(t.Name(), "/", "-")
+		s := newStorage(t, c, key)
+
+		return storagetest.Backend{
+			Storage: s,
+			Corrupt: func(t *testing.T) {
+				_, err := c.Put(context.Background(), key, `{"schema":2,"exp":"soon"}`)
+				require.NoError(t, err)
+			},
+		}
+	})
+}
+
+func watched(t *testing.T, ch <-chan shared.Snapshot) shared.Snapshot {
+	t.Helper()
+
+	select {
+	case snapshot, ok := <-ch:
+		require.True(t, ok, "watch was closed")
+
+		return snapshot
+	case <-time.After(5 * time.Second):
+		t.Fatal("stored token was not watched")
+	}
+
+	return shared.Snapshot{}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := newEtcd(t)
+	leader := newStorage(t, c, "tokens-rotate")
+	follower := newStorage(t, c, "tokens-rotate")
+
+	ch := follower.Watch(ctx)
+
+	// the watch is created in the background
+	time.Sleep(100 * time.Millisecond)
+
+	token := shared.Token{AccessToken: "xoxe.xoxp-1", Exp: 1685800000, RefreshToken: "xoxe-1"}
+	require.NoError(t, leader.Store(ctx, shared.Snapshot{Token: token}, ""))
+
+	snapshot := watched(t, ch)
+	assert.Equal(t, token, snapshot.Token)
+
+	stored, err := leader.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, stored.Version, snapshot.Version)
+
+	cancel()
+
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+// breakingWatcher closes the watches it created when broken, a watch
+// created while it is held waits for release.
+type breakingWatcher struct {
+	clientv3.Watcher
+
+	mu      sync.Mutex
+	cancels []context.CancelFunc
+	created chan struct{}
+	release chan struct{}
+}
+
+func (w *breakingWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	w.mu.Lock()
+	n := len(w.cancels)
+	ctx, cancel := context.WithCancel(ctx)
+	w.cancels = append(w.cancels, cancel)
+	w.mu.Unlock()
+
+	if n > 0 {
+		<-w.release
+	}
+
+	ch := w.Watcher.Watch(ctx, key, opts...)
+	w.created <- struct{}{}
+
+	return ch
+}
+
+// brk closes the last watch.
+func (w *breakingWatcher) brk() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.cancels[len(w.cancels)-1]()
+}
+
+func TestWatchRestart(t *testing.T) {
+	tests := []struct {
+		name    string
+		compact bool
+	}{
+		{
+			name: "closed watch",
+		},
+		{
+			name:    "compacted revision",
+			compact: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			c := newEtcd(t)
+			leader := newStorage(t, c, "tokens-rotate")
+			follower := newStorage(t, c, "tokens-rotate")
+
+			w := &breakingWatcher{
+				Watcher: c,
+				created: make(chan struct{}, 1),
+				release: make(chan struct{}),
+			}
+			follower.watcher = w
+
+			ch := follower.Watch(ctx)
+			<-w.created
+			time.Sleep(100 * time.Millisecond)
+
+			tokens := []shared.Token{}
+			for i := 1; i <= 3; i++ {
+				tokens = append(tokens, shared.Token{
+					AccessToken:  shared.Secret(fmt.Sprintf("xoxe.xoxp-%d", i)),
+					Exp:          int64(1685800000 + i),
+					RefreshToken: shared.Secret(fmt.Sprintf("xoxe-%d", i)),
+				})
+			}
+
+			require.NoError(t, leader.Store(ctx, shared.Snapshot{Token: tokens[0]}, ""))
+			assert.Equal(t, tokens[0], watched(t, ch).Token)
+
+			// the tokens stored while the watch is closed are not lost
+			w.brk()
+
+			prev, err := leader.Load(ctx)
+			require.NoError(t, err)
+			require.NoError(t, leader.Store(ctx, shared.Snapshot{Token: tokens[1]}, prev.Version))
+
+			prev, err = leader.Load(ctx)
+			require.NoError(t, err)
+			require.NoError(t, leader.Store(ctx, shared.Snapshot{Token: tokens[2]}, prev.Version))
+
+			latest, err := leader.Load(ctx)
+			require.NoError(t, err)
+
+			if tt.compact {
+				res, err := c.Get(ctx, "tokens-rotate")
+				require.NoError(t, err)
+
+				_, err = c.Compact(ctx, res.Header.Revision)
+				require.NoError(t, err)
+			}
+
+			close(w.release)
+			<-w.created
+
+			if !tt.compact {
+				// the watch is created again from the last seen revision
+				assert.Equal(t, tokens[1], watched(t, ch).Token)
+			}
+
+			snapshot := watched(t, ch)
+			assert.Equal(t, tokens[2], snapshot.Token)
+			assert.Equal(t, latest.Version, snapshot.Version)
+
+			// and it keeps watching
+			require.NoError(t, leader.Store(ctx, shared.Snapshot{Token: tokens[0]}, latest.Version))
+			assert.Equal(t, tokens[0], watched(t, ch).Token)
+		})
+	}
+}
+
+func leads(t *testing.T, s *Storage) func() bool {
+	return func() bool {
+		ok, err := s.Lock(context.Background())
+		require.NoError(t, err)
+
+		return ok
+	}
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	c := newEtcd(t)
+	first := newStorage(t, c, "tokens-rotate")
+	second := newStorage(t, c, "tokens-rotate")
+
+	assert.Eventually(t, leads(t, first), 5*time.Second, 10*time.Millisecond)
+	assert.Never(t, leads(t, second), 200*time.Millisecond, 10*time.Millisecond)
+
+	// the leader resigns and the waiting campaign wins
+	require.NoError(t, first.Unlock(ctx))
+	assert.Eventually(t, leads(t, second), 5*time.Second, 10*time.Millisecond)
+	assert.Never(t, leads(t, first), 200*time.Millisecond, 10*time.Millisecond)
+
+	// a revoked lease gives up the leadership
+	second.mu.Lock()
+	second.election.(session).Session.Orphan()
+	_, err := c.Revoke(ctx, second.election.(session).Session.Lease())
+	second.mu.Unlock()
+	require.NoError(t, err)
+
+	assert.Eventually(t, leads(t, first), 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, first.Unlock(ctx))
+	require.NoError(t, second.Unlock(ctx))
+}
+
+func TestStoreLeadership(t *testing.T) {
+	ctx := context.Background()
+	c := newEtcd(t)
+	first := newStorage(t, c, "tokens-rotate")
+	second := newStorage(t, c, "tokens-rotate")
+
+	token := shared.Token{AccessToken: "xoxe.xoxp-1", Exp: 1685800000, RefreshToken: "xoxe-1"}
+
+	assert.Eventually(t, leads(t, first), 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, first.Store(ctx, shared.Snapshot{Token: token}, ""))
+
+	stored, err := first.Load(ctx)
+	require.NoError(t, err)
+
+	// a daemon waiting for the leadership doesn't write
+	assert.False(t, leads(t, second)())
+	assert.ErrorIs(t, second.Store(ctx, shared.Snapshot{Token: token}, stored.Version), shared.ErrNotLeader)
+
+	// neither does a leader whose lease expired, even before it notices
+	first.mu.Lock()
+	_, err = c.Revoke(ctx, first.election.(session).Session.Lease())
+	first.mu.Unlock()
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, first.Store(ctx, shared.Snapshot{Token: token}, stored.Version), shared.ErrNotLeader)
+
+	// the new leader writes, a conflict is still reported as such
+	assert.Eventually(t, leads(t, second), 5*time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, second.Store(ctx, shared.Snapshot{Token: token}, ""), shared.ErrVersionConflict)
+	require.NoError(t, second.Store(ctx, shared.Snapshot{Token: token}, stored.Version))
+
+	require.NoError(t, first.Unlock(ctx))
+	require.NoError(t, second.Unlock(ctx))
+}
@@ -0,0 +1,255 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/slack-utils/tokens-rotate/internal/document"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+const (
+	envAccessToken  = "SLACK_CONFIG_TOKEN"
+	envExp          = "SLACK_CONFIG_TOKEN_EXP"
+	envKind         = "SLACK_CONFIG_TOKEN_KIND"
+	envMovedTo      = "SLACK_CONFIG_TOKEN_MOVED_TO"
+	envRefreshToken = "SLACK_CONFIG_REFRESH_TOKEN"
+)
+
+type format interface {
+	marshal(shared.Token) ([]byte, error)
+	unmarshal([]byte, *shared.Token) error
+}
+
+func newFormat(name, text string, compat bool) (format, error) {
+	switch name {
+	case "", "json":
+		return jsonFormat{compat: compat}, nil
+	case "yaml":
+		return yamlFormat{}, nil
+	case "dotenv":
+		return dotenvFormat{}, nil
+	case "template":
+		return newTemplateFormat(text)
+	}
+
+	return nil, fmt.Errorf("unknown format: %s", name)
+}
+
+// jsonFormat writes the versioned token document.
+type jsonFormat struct {
+	compat bool
+}
+
+func (f jsonFormat) marshal(token shared.Token) ([]byte, error) {
+	return document.Marshal(token, f.compat)
+}
+
+func (jsonFormat) unmarshal(data []byte, token *shared.Token) (err error) {
+	*token, err = document.Unmarshal(data)
+	return err
+}
+
+type yamlFormat struct{}
+
+func (yamlFormat) marshal(token shared.Token) ([]byte, error) {
+	return yaml.Marshal(token)
+}
+
+func (yamlFormat) unmarshal(data []byte, token *shared.Token) error {
+	return yaml.Unmarshal(data, token)
+}
+
+type dotenvFormat struct{}
+
+func (dotenvFormat) marshal(token shared.Token) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "%s=%s\n", envAccessToken, token.AccessToken.Reveal())
+	fmt.Fprintf(buf, "%s=%s\n", envRefreshToken, token.RefreshToken.Reveal())
+	fmt.Fprintf(buf, "%s=%d\n", envExp, token.Exp)
+
+	if token.Kind != "" {
+		fmt.Fprintf(buf, "%s=%s\n", envKind, token.Kind)
+	}
+
+	if token.MovedTo != "" {
+		fmt.Fprintf(buf, "%s=%s\n", envMovedTo, token.MovedTo)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (dotenvFormat) unmarshal(data []byte, token *shared.Token) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return fmt.Errorf("invalid dotenv line: %q", line)
+		}
+
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+		switch strings.TrimSpace(key) {
+		case envAccessToken:
+			token.AccessToken = shared.Secret(value)
+		case envRefreshToken:
+			token.RefreshToken = shared.Secret(value)
+		case envKind:
+			token.Kind = value
+		case envMovedTo:
+			token.MovedTo = value
+		case envExp:
+			exp, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			token.Exp = exp
+		}
+	}
+
+	return scanner.Err()
+}
+
+// templateFormat renders the token with a user template. Reading works by
+// rendering the same template with placeholders and matching the file
+// against the result, so the template must keep every field distinguishable.
+// Kind and MovedTo are optional, a token using one the template doesn't hold
+// is rejected instead of being written without it.
+type templateFormat struct {
+	pattern *regexp.Regexp
+	tmpl    *template.Template
+
+	kind    bool
+	movedTo bool
+}
+
+const (
+	placeholderAccessToken  = "\x00access_token\x00"
+	placeholderExp          = "\x00exp\x00"
+	placeholderKind         = "\x00kind\x00"
+	placeholderMovedTo      = "\x00moved_to\x00"
+	placeholderRefreshToken = "\x00refresh_token\x00"
+)
+
+type templateData struct {
+	AccessToken  string
+	Exp          string
+	Kind         string
+	MovedTo      string
+	RefreshToken string
+}
+
+func newTemplateFormat(text string) (*templateFormat, error) {
+	if text == "" {
+		return nil, fmt.Errorf("template format requires fs.template")
+	}
+
+	tmpl, err := template.New("token").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, templateData{
+		AccessToken:  placeholderAccessToken,
+		Exp:          placeholderExp,
+		Kind:         placeholderKind,
+		MovedTo:      placeholderMovedTo,
+		RefreshToken: placeholderRefreshToken,
+	}); err != nil {
+		return nil, err
+	}
+
+	f := &templateFormat{
+		tmpl:    tmpl,
+		kind:    strings.Contains(buf.String(), placeholderKind),
+		movedTo: strings.Contains(buf.String(), placeholderMovedTo),
+	}
+
+	expr := regexp.QuoteMeta(buf.String())
+	for placeholder, group := range map[string]string{
+		placeholderAccessToken:  `(?P<access_token>.*?)`,
+		placeholderExp:          `(?P<exp>-?\d+)`,
+		placeholderKind:         `(?P<kind>.*?)`,
+		placeholderMovedTo:      `(?P<moved_to>.*?)`,
+		placeholderRefreshToken: `(?P<refresh_token>.*?)`,
+	} {
+		quoted := regexp.QuoteMeta(placeholder)
+		expr = strings.Replace(expr, quoted, group, 1)
+		expr = strings.ReplaceAll(expr, quoted, `.*?`)
+	}
+
+	if f.pattern, err = regexp.Compile(`(?s)^` + expr + `$`); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *templateFormat) marshal(token shared.Token) ([]byte, error) {
+	if token.Kind != "" && !f.kind {
+		return nil, fmt.Errorf("template has no .Kind field to store the %s token kind", token.Kind)
+	}
+
+	if token.MovedTo != "" && !f.movedTo {
+		return nil, fmt.Errorf("template has no .MovedTo field to mark the token as moved")
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err := f.tmpl.Execute(buf, templateData{
+		AccessToken:  token.AccessToken.Reveal(),
+		Exp:          strconv.FormatInt(token.Exp, 10),
+		Kind:         token.Kind,
+		MovedTo:      token.MovedTo,
+		RefreshToken: token.RefreshToken.Reveal(),
+	}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (f *templateFormat) unmarshal(data []byte, token *shared.Token) error {
+	match := f.pattern.FindSubmatch(data)
+	if match == nil {
+		return fmt.Errorf("token file does not match the template")
+	}
+
+	for i, name := range f.pattern.SubexpNames() {
+		value := string(match[i])
+
+		switch name {
+		case "access_token":
+			token.AccessToken = shared.Secret(value)
+		case "refresh_token":
+			token.RefreshToken = shared.Secret(value)
+		case "kind":
+			token.Kind = value
+		case "moved_to":
+			token.MovedTo = value
+		case "exp":
+			exp, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			token.Exp = exp
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package fs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+func TestFormat(t *testing.T) {
+	token := shared.Token{
+		AccessToken:  "xoxe.xoxp-1-access",
+		Exp:          1685800000,
+		RefreshToken: "xoxe-1-refresh",
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		template string
+		compat   bool
+		expected string
+	}{
+		{
+			name:     "json",
+			format:   "json",
+			expected: `{"schema":2,"access_token":"xoxe.xoxp-1-access","exp":1685800000,"refresh_token":"xoxe-1-refresh"}`,
+		},
+		{
+			name:     "json compat",
+			format:   "json",
+			compat:   true,
+			expected: `{"access_token":"xoxe.xoxp-1-access","exp":1685800000,"refresh_token":"xoxe-1-refresh"}`,
+		},
+		{
+			name:     "yaml",
+			format:   "yaml",
+			expected: "access_token: xoxe.xoxp-1-access\nexp: 1685800000\nrefresh_token: xoxe-1-refresh\n",
+		},
+		{
+			name:     "dotenv",
+			format:   "dotenv",
+			expected: "SLACK_CONFIG_TOKEN=xoxe.xoxp-1-access\nSLACK_CONFIG_REFRESH_TOKEN=xoxe-1-refresh\nSLACK_CONFIG_TOKEN_EXP=1685800000\n",
+		},
+		{
+			name:     "slack cli credentials",
+			format:   "template",
+			template: `{"T0000000000":{"token":"{{.AccessToken}}","refresh_token":"{{.RefreshToken}}","exp":{{.Exp}}}}`,
+			expected: `{"T0000000000":{"token":"xoxe.xoxp-1-access","refresh_token":"xoxe-1-refresh","exp":1685800000}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newFormat(tt.format, tt.template, tt.compat)
+			assert.NoError(t, err)
+
+			data, err := f.marshal(token)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, string(data))
+
+			s := &Storage{
+				format:     f,
+				l:          log.WithField("storage", "test"),
+				token_file: filepath.Join(t.TempDir(), "token"),
+			}
+			assert.NoError(t, s.Store(context.Background(), shared.Snapshot{Token: token}, ""))
+
+			snapshot, err := s.Load(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, token, snapshot.Token)
+			assert.Equal(t, shared.ErrVersionConflict, s.Store(context.Background(), snapshot, ""))
+			assert.NoError(t, s.Store(context.Background(), snapshot, snapshot.Version))
+		})
+	}
+}
+
+func TestTemplateFields(t *testing.T) {
+	f, err := newFormat("template", `{"token":"{{.AccessToken}}","refresh_token":"{{.RefreshToken}}","exp":{{.Exp}}}`, false)
+	assert.NoError(t, err)
+
+	// a tombstone or an oauth token can't be written without its field
+	_, err = f.marshal(shared.Token{Exp: 1685800000, MovedTo: "vault"})
+	assert.EqualError(t, err, "template has no .MovedTo field to mark the token as moved")
+
+	_, err = f.marshal(shared.Token{AccessToken: "xoxp-1", Kind: shared.KindOAuth})
+	assert.EqualError(t, err, "template has no .Kind field to store the oauth token kind")
+}
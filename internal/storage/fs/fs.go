@@ -1,20 +1,32 @@
 package fs
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 
 	"github.com/slack-utils/tokens-rotate/internal/shared"
 )
 
+type Options struct {
+	// Format of the token file: json, yaml, dotenv or template.
+	Format string
+	// SchemaCompat keeps writing the json format without the schema field.
+	SchemaCompat bool
+	// Template is the Go template used by the template format.
+	Template  string
+	TokenFile string
+}
+
 type Storage struct {
-	shared.GeneralStorage
+	mu sync.Mutex
 
+	format     format
 	l          *log.Entry
 	name       string
 	token_file string
@@ -24,50 +36,95 @@ func (s *Storage) StorageGetName() string {
 	return s.name
 }
 
-func (s *Storage) Read() error {
+func version(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *Storage) read() ([]byte, error) {
 	if _, err := os.Stat(s.token_file); err != nil {
-		return fmt.Errorf("token file not fount")
+		return nil, fmt.Errorf("token file not fount: %w", shared.ErrNotFound)
+	}
+
+	data, err := os.ReadFile(s.token_file)
+	if err != nil {
+		return nil, fmt.Errorf("cant read token file")
+	}
+
+	return data, nil
+}
+
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	snapshot := shared.Snapshot{}
+
+	if err := ctx.Err(); err != nil {
+		return snapshot, err
 	}
 
-	data, err := ioutil.ReadFile(s.token_file)
+	data, err := s.read()
 	if err != nil {
-		return fmt.Errorf("cant read token file")
+		return snapshot, err
 	}
 
 	if len(data) < 1 {
-		return err
+		return snapshot, fmt.Errorf("token file is empty: %w", shared.ErrNotFound)
 	}
 
-	if err = json.Unmarshal(data, &s.Token); err != nil {
-		return err
+	if err = s.format.unmarshal(data, &snapshot.Token); err != nil {
+		return snapshot, err
 	}
+	snapshot.Version = version(data)
 
-	s.l.Debugf("tokens - %#v", s.Token)
+	s.l.WithField("exp", snapshot.Exp).Debug("token was loaded")
 
-	return nil
+	return snapshot, nil
 }
 
-func (s *Storage) Save() error {
-	data, err := json.Marshal(s.Token)
-	if err != nil {
+// Store replaces the token file only if its content still matches
+// prevVersion. The file is written to a temporary file and renamed, so
+// readers never see a partially written token.
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(s.token_file, data, 0600); err != nil {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := ""
+	if data, err := s.read(); err == nil && len(data) > 0 {
+		current = version(data)
+	}
+
+	if current != prevVersion {
+		return shared.ErrVersionConflict
+	}
+
+	data, err := s.format.marshal(snapshot.Token)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return shared.WriteFile(s.token_file, data, 0600)
 }
 
-func New() *Storage {
-	viper.SetDefault("fs.token_file", fmt.Sprintf("%s/token.json", shared.PathConf()))
+func New(opts Options) (*Storage, error) {
+	if opts.TokenFile == "" {
+		return nil, fmt.Errorf("token file is not set")
+	}
+
+	f, err := newFormat(opts.Format, opts.Template, opts.SchemaCompat)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &Storage{
+		format:     f,
 		l:          log.WithField("storage", "fs"),
 		name:       "fs",
-		token_file: viper.GetString("fs.token_file"),
+		token_file: opts.TokenFile,
 	}
 
-	return s
+	return s, nil
 }
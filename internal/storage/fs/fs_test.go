@@ -0,0 +1,44 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		format   string
+		template string
+	}{
+		{format: "json"},
+		{format: "yaml"},
+		{format: "dotenv"},
+		{
+			format:   "template",
+			template: "token={{.AccessToken}}\nrefresh={{.RefreshToken}}\nexp={{.Exp}}\nkind={{.Kind}}\nmoved_to={{.MovedTo}}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+				file := filepath.Join(t.TempDir(), "token")
+
+				s, err := New(Options{Format: tt.format, Template: tt.template, TokenFile: file})
+				require.NoError(t, err)
+
+				return storagetest.Backend{
+					Storage: s,
+					Corrupt: func(t *testing.T) {
+						require.NoError(t, os.WriteFile(file, []byte("{[\x00"), 0600))
+					},
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+type Options struct {
+	// Seed is stored right away when it has an access or refresh token.
+	Seed shared.Token
+}
+
+// Storage keeps the token in the process memory, it is lost on exit.
+type Storage struct {
+	mu      sync.Mutex
+	name    string
+	token   *shared.Token
+	version int
+}
+
+func (s *Storage) StorageGetName() string {
+	return s.name
+}
+
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return shared.Snapshot{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		return shared.Snapshot{}, fmt.Errorf("memory is empty: %w", shared.ErrNotFound)
+	}
+
+	return shared.Snapshot{
+		Token:   *s.token,
+		Version: strconv.Itoa(s.version),
+	}, nil
+}
+
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := ""
+	if s.token != nil {
+		current = strconv.Itoa(s.version)
+	}
+
+	if current != prevVersion {
+		return shared.ErrVersionConflict
+	}
+
+	token := snapshot.Token
+	s.token = &token
+	s.version++
+
+	return nil
+}
+
+func New(opts Options) *Storage {
+	s := &Storage{
+		name: "memory",
+	}
+
+	if opts.Seed.AccessToken != "" || opts.Seed.RefreshToken != "" {
+		token := opts.Seed
+		s.token = &token
+		s.version = 1
+	}
+
+	return s
+}
@@ -0,0 +1,26 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		return storagetest.Backend{Storage: New(Options{})}
+	})
+}
+
+func TestSeed(t *testing.T) {
+	seed := shared.Token{AccessToken: "xoxe.xoxp-1-access", Exp: 123, RefreshToken: "xoxe-1-refresh"}
+
+	snapshot, err := New(Options{Seed: seed}).Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, seed, snapshot.Token)
+	assert.Equal(t, "1", snapshot.Version)
+}
@@ -0,0 +1,249 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/slack-utils/tokens-rotate/internal/document"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+// versionField keeps the hash version next to the token fields, it is
+// incremented by every save.
+const versionField = "version"
+
+type Options struct {
+	// Client is created from the options below when nil.
+	Client redis.UniversalClient
+	// Addrs of the server, or of the sentinels when MasterName is set.
+	Addrs      []string
+	MasterName string
+	DB         int
+	// Username and Password authenticate with ACL, the password alone
+	// with requirepass.
+	Username         string
+	Password         string
+	SentinelUsername string
+	SentinelPassword string
+	// TLS enables TLS, CAFile, CertFile and KeyFile are optional.
+	TLS      bool
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// Key of the hash holding the token.
+	Key string
+	// Channel receives a message after every save, nothing is published
+	// when it is empty.
+	Channel string
+}
+
+// Message is published on the channel after a save. It never contains the
+// tokens, subscribers reload them from the hash.
+type Message struct {
+	Key     string `json:"key"`
+	Version string `json:"version"`
+	Exp     int64  `json:"exp"`
+}
+
+type Storage struct {
+	client redis.UniversalClient
+
+	channel string
+	key     string
+	l       *log.Entry
+	name    string
+}
+
+func (s *Storage) StorageGetName() string {
+	return s.name
+}
+
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	snapshot := shared.Snapshot{}
+
+	fields, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to read hash: %w", err)
+	}
+
+	if len(fields) == 0 {
+		return snapshot, fmt.Errorf("%w: %s", shared.ErrNotFound, s.key)
+	}
+
+	snapshot.Version = fields[versionField]
+
+	doc := document.Document{}
+	for k, v := range fields {
+		if k != versionField {
+			doc[k] = v
+		}
+	}
+
+	// hash fields are strings, while the current schema keeps exp a number
+	if v, ok := doc["exp"]; ok {
+		if doc["exp"], err = strconv.ParseInt(v.(string), 10, 64); err != nil {
+			return snapshot, fmt.Errorf("invalid exp: %w", err)
+		}
+	}
+
+	if snapshot.Token, err = document.Decode(doc); err != nil {
+		return snapshot, err
+	}
+
+	return snapshot, nil
+}
+
+// Store replaces the hash in a MULTI transaction under WATCH, so it is
+// written only if the version field still equals prevVersion.
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	version := ""
+
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.HGet(ctx, s.key, versionField).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		if current != prevVersion {
+			return shared.ErrVersionConflict
+		}
+
+		next := 1
+		if current != "" {
+			if next, err = strconv.Atoi(current); err != nil {
+				return fmt.Errorf("invalid hash version: %w", err)
+			}
+			next++
+		}
+		version = strconv.Itoa(next)
+
+		values := map[string]any{versionField: version}
+		for k, v := range document.Encode(snapshot.Token) {
+			values[k] = fmt.Sprint(v)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, s.key)
+			pipe.HSet(ctx, s.key, values)
+
+			return nil
+		})
+
+		return err
+	}, s.key)
+	if err != nil {
+		if errors.Is(err, redis.TxFailedErr) || errors.Is(err, shared.ErrVersionConflict) {
+			return shared.ErrVersionConflict
+		}
+
+		return fmt.Errorf("failed to save hash: %w", err)
+	}
+
+	s.publish(ctx, Message{Key: s.key, Version: version, Exp: snapshot.Token.Exp})
+
+	return nil
+}
+
+// publish failures are only logged, the token is already saved and
+// subscribers still see it on their next read.
+func (s *Storage) publish(ctx context.Context, m Message) {
+	if s.channel == "" {
+		return
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		s.l.WithField("err", err).Error("failed to marshal change message")
+
+		return
+	}
+
+	if err := s.client.Publish(ctx, s.channel, data).Err(); err != nil {
+		s.l.WithField("err", err).Warn("failed to publish change message")
+	}
+}
+
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	if !opts.TLS {
+		return nil, nil
+	}
+
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if opts.CAFile != "" {
+		data, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", opts.CAFile)
+		}
+	}
+
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
+
+func NewClient(opts Options) (redis.UniversalClient, error) {
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure tls: %w", err)
+	}
+
+	return redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:            opts.Addrs,
+		DB:               opts.DB,
+		MasterName:       opts.MasterName,
+		Password:         opts.Password,
+		SentinelPassword: opts.SentinelPassword,
+		SentinelUsername: opts.SentinelUsername,
+		TLSConfig:        tlsConfig,
+		Username:         opts.Username,
+	}), nil
+}
+
+func New(opts Options) (*Storage, error) {
+	if opts.Key == "" {
+		return nil, errors.New("redis key is not set")
+	}
+
+	c := opts.Client
+	if c == nil {
+		var err error
+		if c, err = NewClient(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Storage{
+		client: c,
+
+		channel: opts.Channel,
+		key:     opts.Key,
+		l:       log.WithField("storage", "redis"),
+		name:    "redis",
+	}
+
+	return s, nil
+}
@@ -0,0 +1,185 @@
+package redis
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
+)
+
+func newStorage(t *testing.T, m *miniredis.Miniredis, channel string) *Storage {
+	c := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() {
+		c.Close()
+	})
+
+	s, err := New(Options{Client: c, Key: "tokens-rotate", Channel: channel})
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		m := miniredis.RunT(t)
+
+		return storagetest.Backend{
+			Storage: newStorage(t, m, ""),
+			Corrupt: func(t *testing.T) {
+				m.HSet("tokens-rotate", "exp", "soon")
+			},
+		}
+	})
+}
+
+func TestPublish(t *testing.T) {
+	m := miniredis.RunT(t)
+	s := newStorage(t, m, "tokens-rotate")
+
+	sub := s.client.Subscribe(context.Background(), "tokens-rotate")
+	defer sub.Close()
+
+	_, err := sub.Receive(context.Background())
+	require.NoError(t, err)
+
+	token := shared.Token{
+		AccessToken:  "xoxe.xoxp-1",
+		Exp:          1685800000,
+		RefreshToken: "xoxe-1",
+	}
+
+	assert.NoError(t, s.Store(context.Background(), shared.Snapshot{Token: token}, ""))
+
+	select {
+	case msg := <-sub.Channel():
+		res := Message{}
+		assert.NoError(t, json.Unmarshal([]byte(msg.Payload), &res))
+		assert.Equal(t, Message{Key: "tokens-rotate", Version: "1", Exp: token.Exp}, res)
+		assert.NotContains(t, msg.Payload, "xox")
+	case <-time.After(time.Second):
+		t.Fatal("no message was published")
+	}
+
+	assert.Equal(t, "1", m.HGet("tokens-rotate", "version"))
+	assert.Equal(t, "xoxe.xoxp-1", m.HGet("tokens-rotate", "access_token"))
+	assert.Equal(t, "1685800000", m.HGet("tokens-rotate", "exp"))
+	assert.Equal(t, "2", m.HGet("tokens-rotate", "schema"))
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 to use as the
+// CA file and returns it together with its key.
+func writeCert(t *testing.T) (string, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	certFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	return certFile, cert
+}
+
+func TestNewClient(t *testing.T) {
+	caFile, cert := writeCert(t)
+
+	tests := []struct {
+		name    string
+		tls     bool
+		opts    Options
+		wantErr bool
+	}{
+		{
+			name: "acl auth",
+			opts: Options{Username: "rotator", Password: "secret"},
+		},
+		{
+			name:    "wrong password",
+			opts:    Options{Username: "rotator", Password: "wrong"},
+			wantErr: true,
+		},
+		{
+			name: "tls",
+			tls:  true,
+			opts: Options{Username: "rotator", Password: "secret", TLS: true, CAFile: caFile},
+		},
+		{
+			name:    "untrusted server",
+			tls:     true,
+			opts:    Options{Username: "rotator", Password: "secret", TLS: true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := miniredis.NewMiniRedis()
+			if tt.tls {
+				require.NoError(t, m.StartTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+			} else {
+				require.NoError(t, m.Start())
+			}
+			defer m.Close()
+
+			m.RequireUserAuth("rotator", "secret")
+
+			tt.opts.Addrs = []string{m.Addr()}
+			tt.opts.Key = "tokens-rotate"
+
+			s, err := New(tt.opts)
+			require.NoError(t, err)
+			defer s.client.Close()
+
+			_, err = s.Load(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.NotErrorIs(t, err, shared.ErrNotFound)
+
+				return
+			}
+
+			assert.ErrorIs(t, err, shared.ErrNotFound)
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	s3api "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/slack-utils/tokens-rotate/internal/document"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+)
+
+type Options struct {
+	// Client is created from the default AWS configuration and the options
+	// below when nil.
+	Client Client
+	// Endpoint of an S3-compatible store such as MinIO, AWS by default.
+	Endpoint string
+	// UsePathStyle addresses the bucket in the path instead of the host
+	// name, most S3-compatible stores need it.
+	UsePathStyle bool
+	Region       string
+
+	Bucket string
+	// Key of the object holding the token document.
+	Key string
+
+	// SSE is the server-side encryption of the object, AES256 or aws:kms.
+	SSE string
+	// KMSKeyID of the aws:kms encryption, the AWS managed key when empty.
+	KMSKeyID string
+	// SSECustomerKey of 32 bytes encrypts the object with SSE-C, it is
+	// needed to read the object back.
+	SSECustomerKey string
+}
+
+type Client interface {
+	GetObject(context.Context, *s3api.GetObjectInput, ...func(*s3api.Options)) (*s3api.GetObjectOutput, error)
+	PutObject(context.Context, *s3api.PutObjectInput, ...func(*s3api.Options)) (*s3api.PutObjectOutput, error)
+	ListObjectVersions(context.Context, *s3api.ListObjectVersionsInput, ...func(*s3api.Options)) (*s3api.ListObjectVersionsOutput, error)
+}
+
+// Version of the object kept by a bucket with versioning enabled.
+type Storage struct {
+	client Client
+
+	bucket string
+	key    string
+
+	sse      types.ServerSideEncryption
+	kmsKeyID string
+	// sseKey and sseKeyMD5 are base64 encoded for the SSE-C headers.
+	sseKey    string
+	sseKeyMD5 string
+
+	l    *log.Entry
+	name string
+}
+
+func (s *Storage) StorageGetName() string {
+	return s.name
+}
+
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	snapshot := shared.Snapshot{}
+
+	input := &s3api.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &s.key,
+	}
+	if s.sseKey != "" {
+		input.SSECustomerAlgorithm = aws.String(SSEAES256)
+		input.SSECustomerKey = &s.sseKey
+		input.SSECustomerKeyMD5 = &s.sseKeyMD5
+	}
+
+	res, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return snapshot, fmt.Errorf("%w: s3://%s/%s", shared.ErrNotFound, s.bucket, s.key)
+		}
+
+		return snapshot, fmt.Errorf("failed to read object: %w", err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	if snapshot.Token, err = document.Unmarshal(data); err != nil {
+		return snapshot, err
+	}
+
+	snapshot.Version = aws.ToString(res.ETag)
+
+	return snapshot, nil
+}
+
+// Store puts the object only if its ETag is still prevVersion, with the
+// If-Match header, or only if it doesn't exist when prevVersion is empty,
+// with the If-None-Match header.
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	data, err := document.Marshal(snapshot.Token, false)
+	if err != nil {
+		return err
+	}
+
+	input := &s3api.PutObjectInput{
+		Body:        bytes.NewReader(data),
+		Bucket:      &s.bucket,
+		ContentType: aws.String("application/json"),
+		Key:         &s.key,
+	}
+	if s.sse != "" {
+		input.ServerSideEncryption = s.sse
+	}
+	if s.kmsKeyID != "" {
+		input.SSEKMSKeyId = &s.kmsKeyID
+	}
+	if s.sseKey != "" {
+		input.SSECustomerAlgorithm = aws.String(SSEAES256)
+		input.SSECustomerKey = &s.sseKey
+		input.SSECustomerKeyMD5 = &s.sseKeyMD5
+	}
+
+	condition := withHeader("If-None-Match", "*")
+	if prevVersion != "" {
+		condition = withHeader("If-Match", prevVersion)
+	}
+
+	if _, err := s.client.PutObject(ctx, input, condition); err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "PreconditionFailed", "ConditionalRequestConflict":
+				return shared.ErrVersionConflict
+			}
+		}
+
+		return fmt.Errorf("failed to save object: %w", err)
+	}
+
+	return nil
+}
+
+// History lists the versions of the object, the latest first. It needs
+// versioning to be enabled on the bucket, otherwise only the current
+// object is listed. A revision is identified by the ETag of its version,
+// the fingerprint and the expiration are not recorded.
+func (s *Storage) History(ctx context.Context) ([]shared.Revision, error) {
+	revisions := []shared.Revision{}
+	input := &s3api.ListObjectVersionsInput{
+		Bucket: &s.bucket,
+		Prefix: &s.key,
+	}
+
+	for {
+		res, err := s.client.ListObjectVersions(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list object versions: %w", err)
+		}
+
+		for _, v := range res.Versions {
+			if aws.ToString(v.Key) != s.key {
+				continue
+			}
+
+			revisions = append(revisions, shared.Revision{
+				Version: aws.ToString(v.ETag),
+				Created: aws.ToTime(v.LastModified),
+			})
+		}
+
+		if !res.IsTruncated {
+			break
+		}
+
+		input.KeyMarker = res.NextKeyMarker
+		input.VersionIdMarker = res.NextVersionIdMarker
+	}
+
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].Created.After(revisions[j].Created)
+	})
+
+	return revisions, nil
+}
+
+// withHeader adds a header the PutObject input has no field for.
+func withHeader(name, value string) func(*s3api.Options) {
+	return func(o *s3api.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue(name, value))
+	}
+}
+
+func NewClient(ctx context.Context, opts Options) (Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s3api.NewFromConfig(cfg, func(o *s3api.Options) {
+		if opts.Region != "" {
+			o.Region = opts.Region
+		}
+
+		if opts.Endpoint != "" {
+			o.EndpointResolver = s3api.EndpointResolverFromURL(opts.Endpoint)
+		}
+
+		o.UsePathStyle = opts.UsePathStyle
+	}), nil
+}
+
+func New(ctx context.Context, opts Options) (*Storage, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("s3 bucket is not set")
+	}
+
+	if opts.Key == "" {
+		return nil, errors.New("s3 key is not set")
+	}
+
+	s := &Storage{
+		bucket:   opts.Bucket,
+		key:      opts.Key,
+		kmsKeyID: opts.KMSKeyID,
+
+		l:    log.WithField("storage", "s3"),
+		name: "s3",
+	}
+
+	switch opts.SSE {
+	case "":
+	case SSEAES256, SSEKMS:
+		s.sse = types.ServerSideEncryption(opts.SSE)
+	default:
+		return nil, fmt.Errorf("unknown s3 server-side encryption %q", opts.SSE)
+	}
+
+	if opts.KMSKeyID != "" && s.sse != SSEKMS {
+		return nil, errors.New("s3 kms key is set without the aws:kms encryption")
+	}
+
+	if opts.SSECustomerKey != "" {
+		if s.sse != "" {
+			return nil, errors.New("s3 customer key can't be used with the server-side encryption")
+		}
+
+		if len(opts.SSECustomerKey) != 32 {
+			return nil, errors.New("s3 customer key must be 32 bytes")
+		}
+
+		sum := md5.Sum([]byte(opts.SSECustomerKey))
+		s.sseKey = base64.StdEncoding.EncodeToString([]byte(opts.SSECustomerKey))
+		s.sseKeyMD5 = base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	s.client = opts.Client
+	if s.client == nil {
+		var err error
+		if s.client, err = NewClient(ctx, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
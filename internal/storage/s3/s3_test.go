@@ -0,0 +1,305 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3api "github.com/aws/aws-sdk-go-v2/service/s3"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
+)
+
+type memObject struct {
+	body     []byte
+	etag     string
+	id       string
+	modified time.Time
+	headers  http.Header
+}
+
+// memS3 is a stand-in for an S3-compatible store with path-style
+// addressing, conditional puts and object versioning.
+type memS3 struct {
+	mu       sync.Mutex
+	versions map[string][]*memObject
+}
+
+func newMemS3(t *testing.T) (*memS3, *httptest.Server) {
+	m := &memS3{versions: map[string][]*memObject{}}
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+
+	return m, srv
+}
+
+func (m *memS3) latest(path string) *memObject {
+	v := m.versions[path]
+	if len(v) == 0 {
+		return nil
+	}
+
+	return v[len(v)-1]
+}
+
+func (m *memS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Has("versions"):
+		m.list(w, r)
+	case r.Method == http.MethodGet:
+		m.get(w, r)
+	case r.Method == http.MethodPut:
+		m.put(w, r)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (m *memS3) get(w http.ResponseWriter, r *http.Request) {
+	o := m.latest(r.URL.Path)
+	if o == nil {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	if key := o.headers.Get("X-Amz-Server-Side-Encryption-Customer-Key"); key != r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key") {
+		writeError(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+
+	w.Header().Set("ETag", o.etag)
+	w.Header().Set("X-Amz-Version-Id", o.id)
+	_, _ = w.Write(o.body)
+}
+
+func (m *memS3) put(w http.ResponseWriter, r *http.Request) {
+	o := m.latest(r.URL.Path)
+
+	if match := r.Header.Get("If-Match"); match != "" && (o == nil || o.etag != match) {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == "*" && o != nil {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	sum := md5.Sum(body)
+	o = &memObject{
+		body:     body,
+		etag:     `"` + hex.EncodeToString(sum[:]) + `"`,
+		id:       fmt.Sprintf("v%d", len(m.versions[r.URL.Path])+1),
+		modified: time.Now().Add(time.Duration(len(m.versions[r.URL.Path])) * time.Second),
+		headers:  r.Header.Clone(),
+	}
+	m.versions[r.URL.Path] = append(m.versions[r.URL.Path], o)
+
+	w.Header().Set("ETag", o.etag)
+	w.Header().Set("X-Amz-Version-Id", o.id)
+}
+
+func (m *memS3) list(w http.ResponseWriter, r *http.Request) {
+	type version struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified string
+		ETag         string
+	}
+
+	res := struct {
+		XMLName     xml.Name `xml:"ListVersionsResult"`
+		Name        string
+		IsTruncated bool
+		Version     []version
+	}{Name: strings.Trim(r.URL.Path, "/")}
+
+	prefix := r.URL.Path + "/" + r.URL.Query().Get("prefix")
+	for path, versions := range m.versions {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		for i, o := range versions {
+			res.Version = append(res.Version, version{
+				Key:          strings.TrimPrefix(path, r.URL.Path+"/"),
+				VersionId:    o.id,
+				IsLatest:     i == len(versions)-1,
+				LastModified: o.modified.UTC().Format(time.RFC3339),
+				ETag:         o.etag,
+			})
+		}
+	}
+
+	_ = xml.NewEncoder(w).Encode(res)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newClient(srv *httptest.Server) Client {
+	return s3api.New(s3api.Options{
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: s3api.EndpointResolverFromURL(srv.URL),
+		Region:           "us-east-1",
+		UsePathStyle:     true,
+	})
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		m, srv := newMemS3(t)
+
+		s, err := New(context.Background(), Options{Client: newClient(srv), Bucket: "tokens", Key: "tokens-rotate.json"})
+		require.NoError(t, err)
+
+		return storagetest.Backend{
+			Storage: s,
+			Corrupt: func(t *testing.T) {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+
+				m.latest("/tokens/tokens-rotate.json").body = []byte(`{"schema":2,"exp":"soon"}`)
+			},
+		}
+	})
+}
+
+func TestEncryption(t *testing.T) {
+	key := strings.Repeat("k", 32)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		headers map[string]string
+		err     string
+	}{
+		{
+			name:    "SSE-S3",
+			opts:    Options{SSE: "AES256"},
+			headers: map[string]string{"X-Amz-Server-Side-Encryption": "AES256"},
+		},
+		{
+			name: "SSE-KMS",
+			opts: Options{SSE: "aws:kms", KMSKeyID: "alias/tokens-rotate"},
+			headers: map[string]string{
+				"X-Amz-Server-Side-Encryption":                "aws:kms",
+				"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "alias/tokens-rotate",
+			},
+		},
+		{
+			name: "SSE-C",
+			opts: Options{SSECustomerKey: key},
+			headers: map[string]string{
+				"X-Amz-Server-Side-Encryption-Customer-Algorithm": "AES256",
+				"X-Amz-Server-Side-Encryption-Customer-Key":       "a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s=",
+			},
+		},
+		{
+			name: "unknown encryption",
+			opts: Options{SSE: "rot13"},
+			err:  `unknown s3 server-side encryption "rot13"`,
+		},
+		{
+			name: "kms key without kms",
+			opts: Options{KMSKeyID: "alias/tokens-rotate"},
+			err:  "s3 kms key is set without the aws:kms encryption",
+		},
+		{
+			name: "short customer key",
+			opts: Options{SSECustomerKey: "short"},
+			err:  "s3 customer key must be 32 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m, srv := newMemS3(t)
+
+			tt.opts.Client = newClient(srv)
+			tt.opts.Bucket = "tokens"
+			tt.opts.Key = "tokens-rotate.json"
+
+			s, err := New(ctx, tt.opts)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			token := shared.Token{AccessToken: "xoxe.xoxp-1", Exp: 1685800000, RefreshToken: "xoxe-1"}
+			require.NoError(t, s.Store(ctx, shared.Snapshot{Token: token}, ""))
+
+			o := m.latest("/tokens/tokens-rotate.json")
+			for name, value := range tt.headers {
+				assert.Equal(t, value, o.headers.Get(name), name)
+			}
+
+			snapshot, err := s.Load(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, token, snapshot.Token)
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	_, srv := newMemS3(t)
+
+	s := &Storage{
+		client: newClient(srv),
+		bucket: "tokens",
+		key:    "tokens-rotate.json",
+		l:      log.WithField("storage", "s3"),
+		name:   "s3",
+	}
+
+	etags := []string{}
+	prev := ""
+	for i := 1; i <= 3; i++ {
+		token := shared.Token{AccessToken: shared.Secret(fmt.Sprintf("xoxe.xoxp-1-%d", i)), Exp: int64(i)}
+		require.NoError(t, s.Store(ctx, shared.Snapshot{Token: token}, prev))
+
+		snapshot, err := s.Load(ctx)
+		require.NoError(t, err)
+		prev = snapshot.Version
+		etags = append(etags, prev)
+	}
+
+	revisions, err := s.History(ctx)
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+
+	for i, r := range revisions {
+		assert.Equal(t, etags[2-i], r.Version)
+		assert.Empty(t, r.Fingerprint)
+		assert.False(t, r.Created.IsZero())
+	}
+}
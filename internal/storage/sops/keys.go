@@ -0,0 +1,112 @@
+package sops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/keys"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/pgp"
+)
+
+// keyService is the local key service of sops with the age identities and
+// the PGP keyring of the options. Without them, and for the other key
+// types, the keys are found like sops does.
+type keyService struct {
+	keyservice.Server
+
+	identities age.ParsedIdentities
+	keyring    string
+}
+
+func (k keyService) Decrypt(ctx context.Context, req *keyservice.DecryptRequest) (*keyservice.DecryptResponse, error) {
+	var key keys.MasterKey
+
+	switch t := req.Key.GetKeyType().(type) {
+	case *keyservice.Key_AgeKey:
+		if len(k.identities) > 0 {
+			m := &age.MasterKey{Recipient: t.AgeKey.Recipient, EncryptedKey: string(req.Ciphertext)}
+			k.identities.ApplyToMasterKey(m)
+			key = m
+		}
+	case *keyservice.Key_PgpKey:
+		if k.keyring != "" {
+			m := pgp.NewMasterKeyFromFingerprint(t.PgpKey.Fingerprint)
+			m.EncryptedKey = string(req.Ciphertext)
+			pgp.SecRing(k.keyring).ApplyToMasterKey(m)
+			key = m
+		}
+	}
+
+	if key == nil {
+		return k.Server.Decrypt(ctx, req)
+	}
+
+	plaintext, err := key.Decrypt()
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+func (k keyService) Encrypt(ctx context.Context, req *keyservice.EncryptRequest) (*keyservice.EncryptResponse, error) {
+	t, ok := req.Key.GetKeyType().(*keyservice.Key_PgpKey)
+	if !ok || k.keyring == "" {
+		return k.Server.Encrypt(ctx, req)
+	}
+
+	m := pgp.NewMasterKeyFromFingerprint(t.PgpKey.Fingerprint)
+	pgp.PubRing(k.keyring).ApplyToMasterKey(m)
+
+	if err := m.Encrypt(req.Plaintext); err != nil {
+		return nil, err
+	}
+
+	return &keyservice.EncryptResponse{Ciphertext: m.EncryptedDataKey()}, nil
+}
+
+// readIdentities parses the age key file, nil when it isn't set lets sops
+// read SOPS_AGE_KEY, SOPS_AGE_KEY_FILE or its default key file.
+func readIdentities(file string) (age.ParsedIdentities, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	identities := age.ParsedIdentities{}
+	if err := identities.Import(string(data)); err != nil {
+		return nil, fmt.Errorf("invalid age key file %s: %w", file, err)
+	}
+
+	return identities, nil
+}
+
+// keyGroup returns the master keys of the recipients, a new file is
+// encrypted for them.
+func keyGroup(ageRecipients, pgpRecipients []string) (sops.KeyGroup, error) {
+	group := sops.KeyGroup{}
+
+	for _, recipient := range ageRecipients {
+		key, err := age.MasterKeyFromRecipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+
+		group = append(group, key)
+	}
+
+	for _, fp := range pgpRecipients {
+		group = append(group, pgp.NewMasterKeyFromFingerprint(strings.ToUpper(fp)))
+	}
+
+	return group, nil
+}
@@ -0,0 +1,511 @@
+package sops
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	"github.com/getsops/sops/v3/keyservice"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	sopsversion "github.com/getsops/sops/v3/version"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/slack-utils/tokens-rotate/internal/document"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+type Options struct {
+	// File encrypted by sops, in the JSON format with the .json extension
+	// and in YAML otherwise.
+	File string
+	// Key holding the token fields, they are top-level keys of the file
+	// when empty.
+	Key string
+
+	// AgeKeyFile holds the age identities decrypting the file. When empty
+	// the SOPS_AGE_KEY and SOPS_AGE_KEY_FILE variables or the default key
+	// file of sops are used like sops does.
+	AgeKeyFile string
+	// PGPKeyring is a binary keyring, as written by gpg --export-secret-keys,
+	// holding the secret keys decrypting the file and the public keys of
+	// PGPRecipients. When empty, or when it can't decrypt the file, gpg is
+	// used with GNUPGHOME like sops does.
+	PGPKeyring string
+
+	// AgeRecipients and PGPRecipients, fingerprints of keyring keys, encrypt
+	// the data key when the file is created. An existing file keeps its key
+	// groups.
+	AgeRecipients []string
+	PGPRecipients []string
+
+	// GitCommit commits the file to the git working tree holding it after
+	// every save.
+	GitCommit bool
+}
+
+type Storage struct {
+	mu sync.Mutex
+
+	file string
+	json bool
+	key  string
+
+	svcs          []keyservice.KeyServiceClient
+	ageRecipients []string
+	pgpRecipients []string
+
+	git  bool
+	l    *log.Entry
+	name string
+}
+
+// file is a decrypted sops file with its data key.
+type file struct {
+	tree    sops.Tree
+	dataKey []byte
+}
+
+// lockRetryDelay is the interval between attempts to lock a file held by
+// another daemon.
+const lockRetryDelay = 50 * time.Millisecond
+
+var tokenFields = []string{"schema", "access_token", "exp", "refresh_token", "kind", "moved_to"}
+
+func (s *Storage) StorageGetName() string {
+	return s.name
+}
+
+func version(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *Storage) store() sops.Store {
+	if s.json {
+		return &sopsjson.Store{}
+	}
+
+	return &sopsyaml.Store{}
+}
+
+// read returns the decrypted file, nil when it doesn't exist yet. The MAC
+// of the file is checked like sops -d does.
+func (s *Storage) read() (*file, []byte, error) {
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) || err == nil && len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("cant read sops file: %w", err)
+	}
+
+	tree, err := s.store().LoadEncryptedFile(data)
+	if errors.Is(err, sops.MetadataNotFound) {
+		return nil, nil, errors.New("file is not encrypted by sops")
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid sops file: %w", err)
+	}
+
+	dataKey, err := tree.Metadata.GetDataKeyWithKeyServices(s.svcs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt the sops data key: %w", err)
+	}
+
+	cipher := aes.NewCipher()
+
+	mac, err := tree.Decrypt(dataKey, cipher)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt sops file: %w", err)
+	}
+
+	fileMAC, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil || fileMAC != mac {
+		return nil, nil, errors.New("sops file mac mismatch")
+	}
+
+	return &file{tree: tree, dataKey: dataKey}, data, nil
+}
+
+// newFile returns an empty file with a new data key encrypted for the
+// recipients.
+func (s *Storage) newFile() (*file, error) {
+	if len(s.ageRecipients) == 0 && len(s.pgpRecipients) == 0 {
+		return nil, errors.New("no age or pgp recipients to create the sops file")
+	}
+
+	group, err := keyGroup(s.ageRecipients, s.pgpRecipients)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &file{
+		tree: sops.Tree{
+			Branches: sops.TreeBranches{sops.TreeBranch{}},
+			Metadata: sops.Metadata{
+				KeyGroups:         []sops.KeyGroup{group},
+				UnencryptedSuffix: sops.DefaultUnencryptedSuffix,
+				Version:           sopsversion.Version,
+			},
+		},
+	}
+
+	dataKey, errs := f.tree.GenerateDataKeyWithKeyServices(s.svcs)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to encrypt the sops data key: %v", errs)
+	}
+	f.dataKey = dataKey
+
+	return f, nil
+}
+
+// marshal encrypts the file with its data key and returns it in the sops
+// format.
+func (s *Storage) marshal(f *file) ([]byte, error) {
+	cipher := aes.NewCipher()
+
+	mac, err := f.tree.Encrypt(f.dataKey, cipher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt sops file: %w", err)
+	}
+
+	f.tree.Metadata.LastModified = time.Now().UTC()
+	f.tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, f.dataKey, f.tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt sops file mac: %w", err)
+	}
+
+	return s.store().EmitEncryptedFile(f.tree)
+}
+
+func lookup(branch sops.TreeBranch, key string) (interface{}, bool) {
+	for _, item := range branch {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+func remove(branch sops.TreeBranch, key string) sops.TreeBranch {
+	for i, item := range branch {
+		if item.Key == key {
+			return append(branch[:i], branch[i+1:]...)
+		}
+	}
+
+	return branch
+}
+
+// branch returns the branch holding the token fields, nil when the file
+// has none.
+func (s *Storage) branch(f *file) sops.TreeBranch {
+	if len(f.tree.Branches) == 0 {
+		return nil
+	}
+
+	if s.key == "" {
+		return f.tree.Branches[0]
+	}
+
+	value, _ := lookup(f.tree.Branches[0], s.key)
+	branch, _ := value.(sops.TreeBranch)
+
+	return branch
+}
+
+func (s *Storage) token(f *file) (shared.Token, bool, error) {
+	doc := document.Document{}
+
+	for _, field := range tokenFields {
+		if value, ok := lookup(s.branch(f), field); ok {
+			doc[field] = value
+		}
+	}
+
+	if len(doc) == 0 {
+		return shared.Token{}, false, nil
+	}
+
+	token, err := document.Decode(doc)
+
+	return token, true, err
+}
+
+func (s *Storage) setToken(f *file, token shared.Token) {
+	branch := s.branch(f)
+	doc := document.Encode(token)
+
+	for _, field := range tokenFields {
+		value, ok := doc[field]
+		if !ok {
+			branch = remove(branch, field)
+			continue
+		}
+
+		// sops encrypts int but not int64 values
+		if n, ok := value.(int64); ok {
+			value = int(n)
+		}
+
+		branch = branch.Set([]interface{}{field}, value)
+	}
+
+	if len(f.tree.Branches) == 0 {
+		f.tree.Branches = sops.TreeBranches{sops.TreeBranch{}}
+	}
+
+	if s.key == "" {
+		f.tree.Branches[0] = branch
+	} else {
+		f.tree.Branches[0] = f.tree.Branches[0].Set([]interface{}{s.key}, branch)
+	}
+}
+
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	snapshot := shared.Snapshot{}
+
+	if err := ctx.Err(); err != nil {
+		return snapshot, err
+	}
+
+	f, data, err := s.read()
+	if err != nil {
+		return snapshot, err
+	}
+
+	if f == nil {
+		return snapshot, fmt.Errorf("sops file not found: %w", shared.ErrNotFound)
+	}
+
+	token, ok, err := s.token(f)
+	if err != nil {
+		return snapshot, err
+	}
+
+	if !ok {
+		return snapshot, fmt.Errorf("no token in sops file: %w", shared.ErrNotFound)
+	}
+
+	snapshot.Token = token
+	snapshot.Version = version(data)
+
+	s.l.WithField("exp", snapshot.Exp).Debug("token was loaded")
+
+	return snapshot, nil
+}
+
+// Store re-encrypts the file with the new token only if its content still
+// matches prevVersion. The data key, the key groups and the other keys of
+// the file are kept, a missing file is created for the recipients. Daemons
+// sharing the file store one at a time under an flock of its directory, the
+// content is checked again right before the new file replaces it, so an
+// edit by hand in the meantime isn't overwritten either.
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, data, err := s.read()
+	if err != nil {
+		return err
+	}
+	original := data
+
+	current := ""
+	if f != nil {
+		if _, ok, _ := s.token(f); ok {
+			current = version(data)
+		}
+	}
+
+	if current != prevVersion {
+		return shared.ErrVersionConflict
+	}
+
+	if f == nil {
+		if f, err = s.newFile(); err != nil {
+			return err
+		}
+	}
+
+	s.setToken(f, snapshot.Token)
+
+	if data, err = s.marshal(f); err != nil {
+		return err
+	}
+
+	tmp := s.file + ".tmp"
+
+	if err := shared.SyncFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	if changed, err := s.changed(original); err != nil || changed {
+		os.Remove(tmp)
+
+		if err != nil {
+			return err
+		}
+
+		return shared.ErrVersionConflict
+	}
+
+	if err := os.Rename(tmp, s.file); err != nil {
+		return err
+	}
+
+	if err := shared.SyncDir(filepath.Dir(s.file)); err != nil {
+		return err
+	}
+
+	// the token is saved, the caller learns that the history is incomplete
+	if s.git {
+		if err := s.commit(ctx, snapshot.Token); err != nil {
+			return fmt.Errorf("token was saved but not committed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// lock takes an exclusive flock of the directory holding the file, the
+// file itself is replaced on every save. It waits until ctx is done.
+func (s *Storage) lock(ctx context.Context) (func(), error) {
+	dir, err := os.Open(filepath.Dir(s.file))
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			dir.Close()
+			return nil, fmt.Errorf("failed to lock sops file: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			dir.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockRetryDelay):
+		}
+	}
+
+	return func() {
+		// closing the descriptor releases the lock
+		dir.Close()
+	}, nil
+}
+
+// changed reports whether the content of the file is no longer original,
+// nil stands for a missing or empty file.
+func (s *Storage) changed(original []byte) (bool, error) {
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return original != nil, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("cant read sops file: %w", err)
+	}
+
+	if original == nil {
+		return len(bytes.TrimSpace(data)) != 0, nil
+	}
+
+	return !bytes.Equal(data, original), nil
+}
+
+// commit records the file in the git working tree holding it, other
+// changes of the working tree are left alone.
+func (s *Storage) commit(ctx context.Context, token shared.Token) error {
+	dir, name := filepath.Split(s.file)
+	message := fmt.Sprintf("Rotate Slack token, expires %s", time.Unix(token.Exp, 0).UTC().Format(time.RFC3339))
+
+	for _, args := range [][]string{
+		{"add", "--", name},
+		{"commit", "--quiet", "--message", message, "--", name},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %w: %s", args[0], err, bytes.TrimSpace(out))
+		}
+	}
+
+	s.l.Debug("sops file was committed")
+
+	return nil
+}
+
+func New(opts Options) (*Storage, error) {
+	if opts.File == "" {
+		return nil, errors.New("sops file is not set")
+	}
+
+	file, err := filepath.Abs(opts.File)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := readIdentities(opts.AgeKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// the recipients are only used to create the file, they are checked
+	// right away
+	if _, err := keyGroup(opts.AgeRecipients, opts.PGPRecipients); err != nil {
+		return nil, err
+	}
+
+	svc := keyService{identities: identities, keyring: opts.PGPKeyring}
+
+	s := &Storage{
+		file: file,
+		json: strings.EqualFold(filepath.Ext(file), ".json"),
+		key:  opts.Key,
+
+		svcs:          []keyservice.KeyServiceClient{keyservice.NewCustomLocalClient(svc)},
+		ageRecipients: opts.AgeRecipients,
+		pgpRecipients: opts.PGPRecipients,
+
+		git:  opts.GitCommit,
+		l:    log.WithField("storage", "sops"),
+		name: "sops",
+	}
+
+	return s, nil
+}
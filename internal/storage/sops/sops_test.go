@@ -0,0 +1,546 @@
+package sops
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/getsops/sops/v3/decrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
+)
+
+// The files of testdata were encrypted by sops 3.8.1 with the keys of
+// testdata, see testdata/README.md.
+const (
+	ageRecipient   = "age19ujazlf3qmvzx3crwgmswawtxcdp6s43h4u9p6llpgtdgalka5psuyvqql"
+	pgpFingerprint = "F93800580CCA0FA92AC983D61E28B9D68E53A1D9"
+)
+
+func testdata(t *testing.T, name string) string {
+	path, err := filepath.Abs(filepath.Join("testdata", name))
+	require.NoError(t, err)
+
+	return path
+}
+
+// isolate hides the keys of the environment from sops, so only the keys
+// of a test are used.
+func isolate(t *testing.T) {
+	for _, env := range []string{"SOPS_AGE_KEY", "SOPS_AGE_KEY_FILE"} {
+		t.Setenv(env, "")
+		require.NoError(t, os.Unsetenv(env))
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("GNUPGHOME", t.TempDir())
+}
+
+// copyFile copies a file of testdata to a new directory.
+func copyFile(t *testing.T, name string) string {
+	data, err := os.ReadFile(testdata(t, name))
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, data, 0600))
+
+	return file
+}
+
+// ageKeys writes a key file holding the age keys of testdata.
+func ageKeys(t *testing.T, names ...string) string {
+	keys := []byte{}
+	for _, name := range names {
+		data, err := os.ReadFile(testdata(t, name))
+		require.NoError(t, err)
+
+		keys = append(keys, data...)
+	}
+
+	file := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(file, keys, 0600))
+
+	return file
+}
+
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		opts Options
+	}{
+		{
+			name: "age yaml",
+			file: "token.yaml",
+			opts: Options{AgeKeyFile: testdata(t, "age1.txt"), AgeRecipients: []string{ageRecipient}},
+		},
+		{
+			name: "age json",
+			file: "token.json",
+			opts: Options{AgeKeyFile: testdata(t, "age1.txt"), AgeRecipients: []string{ageRecipient}},
+		},
+		{
+			name: "pgp nested",
+			file: "secrets.yaml",
+			opts: Options{Key: "slack", PGPKeyring: testdata(t, "keyring.gpg"), PGPRecipients: []string{pgpFingerprint}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+				isolate(t)
+
+				opts := tt.opts
+				opts.File = filepath.Join(t.TempDir(), tt.file)
+
+				s, err := New(opts)
+				require.NoError(t, err)
+
+				return storagetest.Backend{
+					Storage: s,
+					Corrupt: func(t *testing.T) {
+						f, _, err := s.read()
+						require.NoError(t, err)
+
+						branch := s.branch(f).Set([]interface{}{"exp"}, "soon")
+						if s.key == "" {
+							f.tree.Branches[0] = branch
+						} else {
+							f.tree.Branches[0] = f.tree.Branches[0].Set([]interface{}{s.key}, branch)
+						}
+
+						data, err := s.marshal(f)
+						require.NoError(t, err)
+						require.NoError(t, os.WriteFile(s.file, data, 0600))
+					},
+				}
+			})
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		opts   Options
+		env    map[string]string
+		access string
+		err    string
+	}{
+		{
+			name:   "age",
+			file:   "age.sops.yaml",
+			opts:   Options{AgeKeyFile: testdata(t, "age1.txt")},
+			access: "xoxe.xoxp-1-age",
+		},
+		{
+			name:   "age key of sops",
+			file:   "age.sops.yaml",
+			env:    map[string]string{"SOPS_AGE_KEY_FILE": testdata(t, "age1.txt")},
+			access: "xoxe.xoxp-1-age",
+		},
+		{
+			name: "other age key",
+			file: "age.sops.yaml",
+			opts: Options{AgeKeyFile: testdata(t, "age2.txt")},
+			err:  "failed to decrypt the sops data key",
+		},
+		{
+			name:   "pgp json",
+			file:   "pgp.sops.json",
+			opts:   Options{Key: "slack", PGPKeyring: testdata(t, "keyring.gpg")},
+			access: "xoxe.xoxp-1-pgp",
+		},
+		{
+			name:   "key groups with age and pgp",
+			file:   "groups.sops.yaml",
+			opts:   Options{AgeKeyFile: testdata(t, "age1.txt"), PGPKeyring: testdata(t, "keyring.gpg")},
+			access: "xoxe.xoxp-1-groups",
+		},
+		{
+			name:   "key groups with age",
+			file:   "groups.sops.yaml",
+			opts:   Options{AgeKeyFile: ageKeys(t, "age1.txt", "age2.txt")},
+			access: "xoxe.xoxp-1-groups",
+		},
+		{
+			name: "key groups below the threshold",
+			file: "groups.sops.yaml",
+			opts: Options{AgeKeyFile: testdata(t, "age2.txt")},
+			err:  "failed to decrypt the sops data key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			opts := tt.opts
+			opts.File = testdata(t, tt.file)
+
+			s, err := New(opts)
+			require.NoError(t, err)
+
+			snapshot, err := s.Load(context.Background())
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.access, snapshot.AccessToken.Reveal())
+			assert.Equal(t, int64(1685800000), snapshot.Exp)
+		})
+	}
+}
+
+// sopsDecrypt decrypts file like sops -d does, with the age and PGP keys of
+// testdata. It is also decrypted by the sops binary when it is installed.
+func sopsDecrypt(t *testing.T, file string) map[string]any {
+	t.Helper()
+
+	gnupg := t.TempDir()
+	keyring, err := os.ReadFile(testdata(t, "keyring.gpg"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(gnupg, "secring.gpg"), keyring, 0600))
+
+	isolate(t)
+	t.Setenv("SOPS_AGE_KEY_FILE", ageKeys(t, "age1.txt", "age2.txt"))
+	t.Setenv("GNUPGHOME", gnupg)
+
+	format := "yaml"
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		format = "json"
+	}
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	plain, err := decrypt.Data(data, format)
+	require.NoError(t, err)
+
+	if bin, err := exec.LookPath("sops"); err == nil {
+		out, err := exec.Command(bin, "--disable-version-check", "-d", file).Output()
+		require.NoError(t, err)
+		assert.Equal(t, string(plain), string(out))
+	} else {
+		t.Log("sops is not installed, the file is only decrypted by the sops packages")
+	}
+
+	doc := map[string]any{}
+	require.NoError(t, yaml.Unmarshal(plain, &doc))
+
+	return doc
+}
+
+func TestStore(t *testing.T) {
+	token := shared.Token{AccessToken: "xoxe.xoxp-2", Exp: 1685843200, RefreshToken: "xoxe-2", Kind: shared.KindOAuth}
+
+	tests := []struct {
+		name string
+		file string
+		opts Options
+		key  string
+		kept map[string]any
+	}{
+		{
+			name: "age",
+			file: "age.sops.yaml",
+			opts: Options{AgeKeyFile: testdata(t, "age1.txt")},
+			kept: map[string]any{
+				"database":           map[string]any{"password": "secret", "port": 5432},
+				"region_unencrypted": "eu-west-1",
+			},
+		},
+		{
+			name: "pgp json",
+			file: "pgp.sops.json",
+			opts: Options{Key: "slack", PGPKeyring: testdata(t, "keyring.gpg")},
+			key:  "slack",
+			kept: map[string]any{"region_unencrypted": "eu-west-1"},
+		},
+		{
+			name: "key groups",
+			file: "groups.sops.yaml",
+			opts: Options{AgeKeyFile: testdata(t, "age1.txt"), PGPKeyring: testdata(t, "keyring.gpg")},
+		},
+		{
+			name: "new file",
+			file: "token.yaml",
+			opts: Options{
+				AgeKeyFile:    testdata(t, "age1.txt"),
+				AgeRecipients: []string{ageRecipient},
+				PGPKeyring:    testdata(t, "keyring.gpg"),
+				PGPRecipients: []string{pgpFingerprint},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			isolate(t)
+
+			opts := tt.opts
+			opts.File = filepath.Join(t.TempDir(), tt.file)
+
+			_, err := os.Stat(testdata(t, tt.file))
+			existing := err == nil
+			if existing {
+				opts.File = copyFile(t, tt.file)
+			}
+
+			s, err := New(opts)
+			require.NoError(t, err)
+
+			prev := ""
+			var groups any
+			if existing {
+				snapshot, err := s.Load(ctx)
+				require.NoError(t, err)
+				prev = snapshot.Version
+
+				groups = metadata(t, opts.File)["key_groups"]
+			}
+
+			require.NoError(t, s.Store(ctx, shared.Snapshot{Token: token}, prev))
+
+			data, err := os.ReadFile(opts.File)
+			require.NoError(t, err)
+			assert.NotContains(t, string(data), "xoxe")
+
+			doc := sopsDecrypt(t, opts.File)
+
+			fields := doc
+			if tt.key != "" {
+				fields, _ = doc[tt.key].(map[string]any)
+			}
+
+			stored := map[string]any{}
+			for _, field := range tokenFields {
+				if value, ok := fields[field]; ok {
+					stored[field] = value
+				}
+			}
+
+			assert.Equal(t, map[string]any{
+				"schema":        2,
+				"access_token":  "xoxe.xoxp-2",
+				"exp":           1685843200,
+				"refresh_token": "xoxe-2",
+				"kind":          "oauth",
+			}, stored)
+
+			for k, v := range tt.kept {
+				assert.Equal(t, v, doc[k])
+			}
+
+			// the file keeps its key groups and data key
+			meta := metadata(t, opts.File)
+			if groups != nil {
+				assert.Equal(t, groups, meta["key_groups"])
+			}
+
+			if tt.file == "groups.sops.yaml" {
+				assert.Equal(t, 2, meta["shamir_threshold"])
+			}
+		})
+	}
+}
+
+// metadata returns the sops key of a file, which is also valid YAML when
+// the file is in the JSON format.
+func metadata(t *testing.T, file string) map[string]any {
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+
+	doc := map[string]any{}
+	require.NoError(t, yaml.Unmarshal(data, &doc))
+
+	meta, ok := doc["sops"].(map[string]any)
+	require.True(t, ok)
+
+	// the encrypted data key of the groups is kept, the other fields change
+	// with every save
+	delete(meta, "lastmodified")
+	delete(meta, "mac")
+
+	return meta
+}
+
+func TestMAC(t *testing.T) {
+	ctx := context.Background()
+	isolate(t)
+
+	file := filepath.Join(t.TempDir(), "token.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("region_unencrypted: eu-west-1\n"), 0600))
+
+	s, err := New(Options{File: file, AgeKeyFile: testdata(t, "age1.txt"), AgeRecipients: []string{ageRecipient}})
+	require.NoError(t, err)
+
+	// a plain file isn't overwritten
+	err = s.Store(ctx, shared.Snapshot{}, "")
+	assert.EqualError(t, err, "file is not encrypted by sops")
+
+	// unencrypted values are covered by the MAC
+	file = copyFile(t, "age.sops.yaml")
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, []byte(strings.Replace(string(data), "eu-west-1", "us-east-1", 1)), 0600))
+
+	s, err = New(Options{File: file, AgeKeyFile: testdata(t, "age1.txt")})
+	require.NoError(t, err)
+
+	_, err = s.Load(ctx)
+	assert.EqualError(t, err, "sops file mac mismatch")
+
+	err = s.Store(ctx, shared.Snapshot{}, "")
+	assert.EqualError(t, err, "sops file mac mismatch")
+}
+
+func TestStoreLock(t *testing.T) {
+	isolate(t)
+	file := filepath.Join(t.TempDir(), "token.yaml")
+
+	s, err := New(Options{
+		File:          file,
+		AgeKeyFile:    testdata(t, "age1.txt"),
+		AgeRecipients: []string{ageRecipient},
+	})
+	require.NoError(t, err)
+
+	// another daemon is saving the token
+	dir, err := os.Open(filepath.Dir(file))
+	require.NoError(t, err)
+	require.NoError(t, syscall.Flock(int(dir.Fd()), syscall.LOCK_EX))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	token := shared.Token{AccessToken: "xoxe.xoxp-1", Exp: 1685800000, RefreshToken: "xoxe-1"}
+	assert.ErrorIs(t, s.Store(ctx, shared.Snapshot{Token: token}, ""), context.DeadlineExceeded)
+
+	_, err = os.Stat(file)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// the lock is released with the descriptor
+	require.NoError(t, dir.Close())
+	require.NoError(t, s.Store(context.Background(), shared.Snapshot{Token: token}, ""))
+
+	snapshot, err := s.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, token, snapshot.Token)
+}
+
+func TestChanged(t *testing.T) {
+	s := &Storage{file: filepath.Join(t.TempDir(), "token.yaml")}
+
+	changed, err := s.changed(nil)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	require.NoError(t, os.WriteFile(s.file, []byte("first"), 0600))
+
+	changed, err = s.changed(nil)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = s.changed([]byte("first"))
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	require.NoError(t, os.WriteFile(s.file, []byte("edited"), 0600))
+
+	changed, err = s.changed([]byte("first"))
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	ctx := context.Background()
+	isolate(t)
+	dir := t.TempDir()
+
+	git(t, dir, "init", "--quiet")
+	git(t, dir, "config", "user.name", "tokens-rotate")
+	git(t, dir, "config", "user.email", "tokens-rotate@example.com")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.txt"), []byte("other"), 0600))
+
+	s, err := New(Options{
+		File:          filepath.Join(dir, "token.yaml"),
+		AgeKeyFile:    testdata(t, "age1.txt"),
+		AgeRecipients: []string{ageRecipient},
+		GitCommit:     true,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Store(ctx, shared.Snapshot{Token: shared.Token{Exp: 1685800000}}, ""))
+
+	snapshot, err := s.Load(ctx)
+	require.NoError(t, err)
+	require.NoError(t, s.Store(ctx, shared.Snapshot{Token: shared.Token{Exp: 1685843200}}, snapshot.Version))
+
+	assert.Equal(t,
+		"Rotate Slack token, expires 2023-06-04T01:46:40Z\nRotate Slack token, expires 2023-06-03T13:46:40Z",
+		git(t, dir, "log", "--format=%s"),
+	)
+	assert.Equal(t, "token.yaml", git(t, dir, "ls-files"))
+	assert.Equal(t, "?? other.txt", git(t, dir, "status", "--porcelain"))
+}
+
+func TestGitCommitFailed(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	ctx := context.Background()
+	isolate(t)
+
+	// the directory is not a git working tree
+	dir := t.TempDir()
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+
+	s, err := New(Options{
+		File:          filepath.Join(dir, "token.yaml"),
+		AgeKeyFile:    testdata(t, "age1.txt"),
+		AgeRecipients: []string{ageRecipient},
+		GitCommit:     true,
+	})
+	require.NoError(t, err)
+
+	token := shared.Token{AccessToken: "xoxe.xoxp-1", Exp: 1685800000, RefreshToken: "xoxe-1"}
+	assert.ErrorContains(t, s.Store(ctx, shared.Snapshot{Token: token}, ""), "token was saved but not committed")
+
+	// the token is saved all the same
+	snapshot, err := s.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, token, snapshot.Token)
+}
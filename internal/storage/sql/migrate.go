@@ -0,0 +1,188 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+type migration struct {
+	version    int
+	statements []string
+}
+
+// loadMigrations returns the embedded migrations ordered by the version
+// prefix of their file names.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	list := []migration{}
+
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration name: %s", e.Name())
+		}
+
+		data, err := migrations.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := migration{version: version}
+
+		// drivers differ in running several statements at once
+		for _, s := range strings.Split(string(data), ";") {
+			if s = strings.TrimSpace(s); s != "" {
+				m.statements = append(m.statements, s)
+			}
+		}
+
+		list = append(list, m)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].version < list[j].version
+	})
+
+	return list, nil
+}
+
+// migrationLock is the key of the advisory lock held while migrating.
+const migrationLock = "tokens-rotate-migrations"
+
+// migrate applies the migrations newer than the version recorded in the
+// schema_migrations table. Daemons starting together migrate one at a time
+// under an advisory lock on PostgreSQL and MySQL, SQLite serializes the
+// migration transactions and a migration applied meanwhile is skipped.
+func (s *Storage) migrate(ctx context.Context) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(
+		ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY)",
+	); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	unlock, err := s.lockMigrations(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer unlock()
+
+	current := sql.NullInt64{}
+	if err := conn.QueryRowContext(
+		ctx,
+		"SELECT MAX(version) FROM schema_migrations",
+	).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	list, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range list {
+		if int64(m.version) <= current.Int64 {
+			continue
+		}
+
+		applied, err := s.apply(ctx, conn, m)
+		if err != nil {
+			return fmt.Errorf("migration %d was failed: %w", m.version, err)
+		}
+
+		if applied {
+			s.l.WithField("version", m.version).Info("schema was migrated")
+		}
+	}
+
+	return nil
+}
+
+// lockMigrations takes the advisory lock of the driver on conn, the
+// returned function releases it.
+func (s *Storage) lockMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	lock, unlock := "", ""
+
+	switch s.driver {
+	case "postgres":
+		lock = "SELECT pg_advisory_lock(hashtext($1))"
+		unlock = "SELECT pg_advisory_unlock(hashtext($1))"
+	case "mysql":
+		// a negative timeout waits until ctx is done
+		lock = "SELECT GET_LOCK(?, -1)"
+		unlock = "SELECT RELEASE_LOCK(?)"
+	default:
+		return func() {}, nil
+	}
+
+	if _, err := conn.ExecContext(ctx, lock, migrationLock); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		// ctx may be done already, the lock is released anyway
+		if _, err := conn.ExecContext(context.Background(), unlock, migrationLock); err != nil {
+			s.l.WithField("err", err).Warn("failed to unlock migrations")
+		}
+	}, nil
+}
+
+// apply runs the migration unless another daemon has applied it since the
+// version was read, it reports whether the migration was applied.
+func (s *Storage) apply(ctx context.Context, conn *sql.Conn, m migration) (bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	n := 0
+	if err := tx.QueryRowContext(
+		ctx,
+		s.rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"),
+		m.version,
+	).Scan(&n); err != nil {
+		return false, err
+	}
+
+	if n > 0 {
+		return false, nil
+	}
+
+	for _, statement := range m.statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		s.rebind("INSERT INTO schema_migrations (version) VALUES (?)"),
+		m.version,
+	); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
@@ -0,0 +1,15 @@
+CREATE TABLE tokens (
+    name VARCHAR(255) NOT NULL PRIMARY KEY,
+    document TEXT NOT NULL,
+    version BIGINT NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE token_history (
+    name VARCHAR(255) NOT NULL,
+    version BIGINT NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    exp BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (name, version)
+);
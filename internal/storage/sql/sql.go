@@ -0,0 +1,318 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// registered drivers: mysql, postgres, sqlite
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+
+	"github.com/slack-utils/tokens-rotate/internal/audit"
+	"github.com/slack-utils/tokens-rotate/internal/document"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+// Clock is the source of the save times, rotator.Clock satisfies it.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type Options struct {
+	// Clock defaults to the system clock.
+	Clock Clock
+	// DB is opened with Driver and DSN when nil.
+	DB *sql.DB
+	// Driver is mysql, postgres or sqlite, the pure Go SQLite driver builds
+	// without cgo. sqlite3 is accepted as sqlite.
+	Driver string
+	DSN    string
+	// Name of the token row, several tokens can share the tables.
+	Name string
+}
+
+type Storage struct {
+	clock Clock
+	db    *sql.DB
+
+	driver string
+	l      *log.Entry
+	name   string
+	token  string
+}
+
+func (s *Storage) StorageGetName() string {
+	return s.name
+}
+
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	snapshot := shared.Snapshot{}
+	data := ""
+	version := int64(0)
+
+	if err := s.db.QueryRowContext(
+		ctx,
+		s.rebind("SELECT document, version FROM tokens WHERE name = ?"),
+		s.token,
+	).Scan(&data, &version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return snapshot, fmt.Errorf("%w: %s", shared.ErrNotFound, s.token)
+		}
+
+		return snapshot, fmt.Errorf("failed to read token: %w", err)
+	}
+
+	token, err := document.Unmarshal([]byte(data))
+	if err != nil {
+		return snapshot, err
+	}
+
+	snapshot.Token = token
+	snapshot.Version = strconv.FormatInt(version, 10)
+
+	return snapshot, nil
+}
+
+// Store updates the row only if its version column still equals
+// prevVersion and appends the rotation to token_history in the same
+// transaction.
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	data, err := document.Marshal(snapshot.Token, false)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := s.clock.Now().UTC()
+	version := int64(1)
+
+	if prevVersion == "" {
+		if _, err := tx.ExecContext(
+			ctx,
+			s.rebind("INSERT INTO tokens (name, document, version, updated_at) VALUES (?, ?, ?, ?)"),
+			s.token, string(data), version, now,
+		); err != nil {
+			// the insert only fails on the primary key when the row is there,
+			// the transaction is released first as it may hold the only
+			// connection
+			tx.Rollback()
+
+			if s.exists(ctx) {
+				return shared.ErrVersionConflict
+			}
+
+			return fmt.Errorf("failed to save token: %w", err)
+		}
+	} else {
+		prev, err := strconv.ParseInt(prevVersion, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid token version: %w", err)
+		}
+		version = prev + 1
+
+		res, err := tx.ExecContext(
+			ctx,
+			s.rebind("UPDATE tokens SET document = ?, version = ?, updated_at = ? WHERE name = ? AND version = ?"),
+			string(data), version, now, s.token, prev,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save token: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to save token: %w", err)
+		}
+
+		if n == 0 {
+			return shared.ErrVersionConflict
+		}
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		s.rebind("INSERT INTO token_history (name, version, fingerprint, exp, created_at) VALUES (?, ?, ?, ?, ?)"),
+		s.token, version, audit.Fingerprint(snapshot.Token.AccessToken.Reveal()), snapshot.Token.Exp, now,
+	); err != nil {
+		return fmt.Errorf("failed to save token history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+
+	return nil
+}
+
+// History lists the rows of token_history for the token, the latest
+// first.
+func (s *Storage) History(ctx context.Context) ([]shared.Revision, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		s.rebind("SELECT version, fingerprint, exp, created_at FROM token_history WHERE name = ? ORDER BY version DESC"),
+		s.token,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token history: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []shared.Revision{}
+	for rows.Next() {
+		version := int64(0)
+		r := shared.Revision{}
+
+		if err := rows.Scan(&version, &r.Fingerprint, &r.Exp, &r.Created); err != nil {
+			return nil, fmt.Errorf("failed to read token history: %w", err)
+		}
+
+		r.Version = strconv.FormatInt(version, 10)
+		revisions = append(revisions, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read token history: %w", err)
+	}
+
+	return revisions, nil
+}
+
+func (s *Storage) exists(ctx context.Context) bool {
+	n := 0
+
+	if err := s.db.QueryRowContext(
+		ctx,
+		s.rebind("SELECT COUNT(*) FROM tokens WHERE name = ?"),
+		s.token,
+	).Scan(&n); err != nil {
+		return false
+	}
+
+	return n > 0
+}
+
+// rebind replaces the ? placeholders with the $n ones of PostgreSQL.
+func (s *Storage) rebind(query string) string {
+	if s.driver != "postgres" {
+		return query
+	}
+
+	b := strings.Builder{}
+	n := 0
+
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// sqliteDSN makes transactions take the write lock when they begin and wait
+// for a busy database, so daemons sharing the file run them one at a time
+// instead of failing. Options set in dsn are kept.
+func sqliteDSN(dsn string) string {
+	params := []string{}
+
+	if !strings.Contains(dsn, "_txlock=") {
+		params = append(params, "_txlock=immediate")
+	}
+
+	if !strings.Contains(dsn, "busy_timeout") {
+		params = append(params, "_pragma=busy_timeout(10000)")
+	}
+
+	if len(params) == 0 {
+		return dsn
+	}
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+
+	return dsn + sep + strings.Join(params, "&")
+}
+
+// New opens the database and applies the embedded migrations.
+func New(ctx context.Context, opts Options) (*Storage, error) {
+	if opts.Name == "" {
+		return nil, errors.New("token name is not set")
+	}
+
+	if opts.Driver == "sqlite3" {
+		opts.Driver = "sqlite"
+	}
+
+	db := opts.DB
+	if db == nil {
+		// the history is read into time.Time, which MySQL only does with
+		// parseTime
+		if opts.Driver == "mysql" {
+			cfg, err := mysql.ParseDSN(opts.DSN)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mysql dsn: %w", err)
+			}
+			cfg.ParseTime = true
+			opts.DSN = cfg.FormatDSN()
+		}
+
+		if opts.Driver == "sqlite" {
+			opts.DSN = sqliteDSN(opts.DSN)
+		}
+
+		var err error
+		if db, err = sql.Open(opts.Driver, opts.DSN); err != nil {
+			return nil, err
+		}
+
+		// SQLite has a single writer, a shared connection avoids busy errors
+		if opts.Driver == "sqlite" {
+			db.SetMaxOpenConns(1)
+		}
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+
+	s := &Storage{
+		clock: opts.Clock,
+		db:    db,
+
+		driver: opts.Driver,
+		l:      log.WithField("storage", "sql"),
+		name:   "sql",
+		token:  opts.Name,
+	}
+
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,212 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slack-utils/tokens-rotate/internal/audit"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/rotatortest"
+)
+
+func newStorage(t *testing.T) *Storage {
+	s, err := New(context.Background(), Options{
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "tokens.db"),
+		Name:   "tokens-rotate",
+	})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		s.db.Close()
+	})
+
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		s := newStorage(t)
+
+		return storagetest.Backend{
+			Storage: s,
+			Corrupt: func(t *testing.T) {
+				_, err := s.db.Exec("UPDATE tokens SET document = ?", `{"schema":2,"exp":"soon"}`)
+				require.NoError(t, err)
+			},
+		}
+	})
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	prev := ""
+	for i := 1; i <= 3; i++ {
+		token := shared.Token{
+			AccessToken:  shared.Secret(fmt.Sprintf("xoxe.xoxp-1-%d", i)),
+			Exp:          int64(1685800000 + i),
+			RefreshToken: shared.Secret(fmt.Sprintf("xoxe-1-%d", i)),
+		}
+
+		require.NoError(t, s.Store(ctx, shared.Snapshot{Token: token}, prev))
+
+		snapshot, err := s.Load(ctx)
+		require.NoError(t, err)
+		prev = snapshot.Version
+	}
+
+	// a rejected save is not recorded
+	assert.ErrorIs(t, s.Store(ctx, shared.Snapshot{}, "1"), shared.ErrVersionConflict)
+
+	revisions, err := s.History(ctx)
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+
+	for i, r := range revisions {
+		n := int64(3 - i)
+
+		assert.Equal(t, strconv.FormatInt(n, 10), r.Version)
+		assert.Equal(t, audit.Fingerprint(fmt.Sprintf("xoxe.xoxp-1-%d", n)), r.Fingerprint)
+		assert.Equal(t, 1685800000+n, r.Exp)
+		assert.WithinDuration(t, time.Now(), r.Created, time.Minute)
+	}
+}
+
+func TestHistoryClock(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2023, 6, 3, 12, 0, 0, 0, time.UTC)
+
+	s, err := New(ctx, Options{
+		Clock:  rotatortest.NewClock(now),
+		Driver: "sqlite",
+		DSN:    filepath.Join(t.TempDir(), "tokens.db"),
+		Name:   "tokens-rotate",
+	})
+	require.NoError(t, err)
+	defer s.db.Close()
+
+	require.NoError(t, s.Store(ctx, shared.Snapshot{Token: shared.Token{Exp: 1}}, ""))
+
+	revisions, err := s.History(ctx)
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.True(t, now.Equal(revisions[0].Created))
+}
+
+func TestMigrate(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "tokens.db")
+
+	// migrations run once, a second start keeps the data
+	for i := 0; i < 2; i++ {
+		s, err := New(context.Background(), Options{Driver: "sqlite", DSN: dsn, Name: "tokens-rotate"})
+		require.NoError(t, err)
+
+		if i == 0 {
+			require.NoError(t, s.Store(context.Background(), shared.Snapshot{Token: shared.Token{Exp: 1}}, ""))
+		}
+
+		snapshot, err := s.Load(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), snapshot.Exp)
+
+		s.db.Close()
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	versions := 0
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&versions))
+
+	list, err := loadMigrations()
+	require.NoError(t, err)
+	assert.Equal(t, len(list), versions)
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "tokens.db")
+
+	// daemons started together apply every migration once
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			s, err := New(context.Background(), Options{Driver: "sqlite", DSN: dsn, Name: "tokens-rotate"})
+			if err == nil {
+				s.db.Close()
+			}
+
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	s := newStorage(t)
+	list, err := loadMigrations()
+	require.NoError(t, err)
+
+	// a migration applied after the version was read is skipped
+	conn, err := s.db.Conn(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	applied, err := s.apply(context.Background(), conn, list[0])
+	require.NoError(t, err)
+	assert.False(t, applied)
+}
+
+func TestSqliteDSN(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{
+			dsn:  "tokens.db",
+			want: "tokens.db?_txlock=immediate&_pragma=busy_timeout(10000)",
+		},
+		{
+			dsn:  "file:tokens.db?mode=rwc",
+			want: "file:tokens.db?mode=rwc&_txlock=immediate&_pragma=busy_timeout(10000)",
+		},
+		{
+			dsn:  "tokens.db?_txlock=deferred&_pragma=busy_timeout(100)",
+			want: "tokens.db?_txlock=deferred&_pragma=busy_timeout(100)",
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, sqliteDSN(tt.dsn))
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		driver string
+		want   string
+	}{
+		{driver: "sqlite", want: "UPDATE t SET a = ? WHERE b = ?"},
+		{driver: "mysql", want: "UPDATE t SET a = ? WHERE b = ?"},
+		{driver: "postgres", want: "UPDATE t SET a = $1 WHERE b = $2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			s := &Storage{driver: tt.driver}
+			assert.Equal(t, tt.want, s.rebind("UPDATE t SET a = ? WHERE b = ?"))
+		})
+	}
+}
@@ -3,16 +3,27 @@ package vault
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/hashicorp/vault-client-go"
 	"github.com/hashicorp/vault-client-go/schema"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 
+	"github.com/slack-utils/tokens-rotate/internal/document"
 	"github.com/slack-utils/tokens-rotate/internal/shared"
 )
 
+type Options struct {
+	// Client is created from the VAULT_* environment variables when nil.
+	Client *vault.Client
+	// SchemaCompat keeps writing the unversioned fields with exp as a string.
+	SchemaCompat bool
+	SecretName   string
+	SecretPath   string
+}
+
 type Auth interface {
 	TokenLookUpSelf(context.Context, ...vault.RequestOption) (*vault.Response[map[string]interface{}], error)
 }
@@ -27,12 +38,11 @@ type Secrets interface {
 }
 
 type Storage struct {
-	shared.GeneralStorage
-
 	auth    Auth
 	secrets Secrets
 	system  System
 
+	compat     bool
 	l          *log.Entry
 	name       string
 	secretName string
@@ -43,93 +53,120 @@ func (s *Storage) StorageGetName() string {
 	return s.name
 }
 
-func (s *Storage) Read() error {
-	s.check()
+func (s *Storage) Load(ctx context.Context) (shared.Snapshot, error) {
+	snapshot := shared.Snapshot{}
+
+	if err := s.check(ctx); err != nil {
+		return snapshot, err
+	}
 
 	value, err := s.secrets.KvV2Read(
-		context.Background(),
+		ctx,
 		s.secretPath,
 		vault.WithMountPath(s.secretName),
 	)
 	if err != nil {
-		return err
+		if vault.IsErrorStatus(err, http.StatusNotFound) {
+			return snapshot, fmt.Errorf("%w: %s", shared.ErrNotFound, err)
+		}
+
+		return snapshot, err
 	}
 
-	s.Token.AccessToken = value.Data.Data["access_token"].(string)
-	s.Token.RefreshToken = value.Data.Data["refresh_token"].(string)
+	if snapshot.Token, err = document.Decode(value.Data.Data); err != nil {
+		return snapshot, err
+	}
 
-	exp, err := strconv.ParseInt(value.Data.Data["exp"].(string), 10, 64)
-	if err != nil {
-		return err
+	if v, ok := value.Data.Metadata["version"]; ok {
+		snapshot.Version = fmt.Sprint(v)
 	}
-	s.Token.Exp = exp
 
-	return nil
+	return snapshot, nil
 }
 
-func (s *Storage) Save() error {
-	s.check()
+// Store uses the KV v2 check-and-set, so the secret is written only if its
+// current version is prevVersion.
+func (s *Storage) Store(ctx context.Context, snapshot shared.Snapshot, prevVersion string) error {
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+
+	cas := 0
+	if prevVersion != "" {
+		var err error
+		if cas, err = strconv.Atoi(prevVersion); err != nil {
+			return fmt.Errorf("invalid secret version: %w", err)
+		}
+	}
+
+	data := document.Encode(snapshot.Token)
+
+	if s.compat {
+		delete(data, "schema")
+		data["exp"] = fmt.Sprintf("%d", snapshot.Token.Exp)
+	}
 
 	if _, err := s.secrets.KvV2Write(
-		context.Background(),
+		ctx,
 		s.secretPath,
 		schema.KvV2WriteRequest{
-			Data: map[string]any{
-				"access_token":  s.Token.AccessToken,
-				"exp":           fmt.Sprintf("%d", s.Token.Exp),
-				"refresh_token": s.Token.RefreshToken,
-			},
+			Data:    map[string]any(data),
+			Options: map[string]any{"cas": cas},
 		},
 		vault.WithMountPath(s.secretName),
 	); err != nil {
-		s.l.WithField("err", err).Fatal("failed to save secret")
+		if strings.Contains(err.Error(), "check-and-set") {
+			return shared.ErrVersionConflict
+		}
+
+		return fmt.Errorf("failed to save secret: %w", err)
 	}
 
 	return nil
 }
 
-func (s *Storage) check() {
+func (s *Storage) check(ctx context.Context) error {
 	if _, err := s.system.ReadHealthStatus(
-		context.Background(),
+		ctx,
 	); err != nil {
-		s.l.WithField("err", err).Fatal("health check was failed")
+		return fmt.Errorf("health check was failed: %w", err)
 	}
 
 	if _, err := s.auth.TokenLookUpSelf(
-		context.Background(),
-	); err != nil {
-		s.l.WithField("err", err).Fatal("token lookup was failed")
-	}
-}
-
-func NewClient() *vault.Client {
-	if c, err := vault.New(
-		vault.WithEnvironment(),
+		ctx,
 	); err != nil {
-		log.WithField("err", err).Fatal("failed get path name")
-	} else {
-		return c
+		return fmt.Errorf("token lookup was failed: %w", err)
 	}
 
 	return nil
 }
 
-func New() *Storage {
-	viper.SetDefault("vault.secret_name", "secret")
-	viper.SetDefault("vault.secret_path", shared.PkgName)
+func NewClient() (*vault.Client, error) {
+	return vault.New(
+		vault.WithEnvironment(),
+	)
+}
 
-	c := NewClient()
+func New(opts Options) (*Storage, error) {
+	c := opts.Client
+	if c == nil {
+		var err error
+		if c, err = NewClient(); err != nil {
+			return nil, err
+		}
+	}
 
 	s := &Storage{
 		auth:    &c.Auth,
 		system:  &c.System,
 		secrets: &c.Secrets,
 
+		compat:     opts.SchemaCompat,
 		l:          log.WithField("storage", "vault"),
 		name:       "vault",
-		secretName: viper.GetString("vault.secret_name"),
-		secretPath: viper.GetString("vault.secret_path"),
+		secretName: opts.SecretName,
+		secretPath: opts.SecretPath,
 	}
 
-	return s
+	return s, nil
 }
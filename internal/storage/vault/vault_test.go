@@ -1,12 +1,20 @@
 package vault
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/hashicorp/vault-client-go"
 	"github.com/hashicorp/vault-client-go/schema"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+
+	"github.com/slack-utils/tokens-rotate/internal/storage/storagetest"
 )
 
 type AuthMock struct {
@@ -51,6 +59,17 @@ func TestStorage(t *testing.T) {
 		"refresh_token": "refresh-token",
 		"exp":           "123",
 	}
+	legacy := map[string]interface{}{
+		"access_token":  "access-token",
+		"refresh_token": "refresh-token",
+		"exp":           "123",
+	}
+	upgraded := map[string]interface{}{
+		"schema":        2,
+		"access_token":  "access-token",
+		"refresh_token": "refresh-token",
+		"exp":           int64(123),
+	}
 
 	auth := &AuthMock{}
 	system := &SystemMock{}
@@ -71,14 +90,24 @@ func TestStorage(t *testing.T) {
 		context.Background(),
 		secret_path,
 	).Return(&vault.Response[schema.KvV2ReadResponse]{
-		Data: schema.KvV2ReadResponse{Data: token},
+		Data: schema.KvV2ReadResponse{
+			Data:     token,
+			Metadata: map[string]interface{}{"version": json.Number("3")},
+		},
 	}, nil)
 
 	secrets.On(
 		"KvV2Write",
 		context.Background(),
 		secret_path,
-		schema.KvV2WriteRequest{Data: token},
+		schema.KvV2WriteRequest{Data: upgraded, Options: map[string]interface{}{"cas": 3}},
+	).Return(&vault.Response[schema.KvV2WriteResponse]{}, nil)
+
+	secrets.On(
+		"KvV2Write",
+		context.Background(),
+		secret_path,
+		schema.KvV2WriteRequest{Data: legacy, Options: map[string]interface{}{"cas": 3}},
 	).Return(&vault.Response[schema.KvV2WriteResponse]{}, nil)
 
 	s := &Storage{
@@ -92,10 +121,97 @@ func TestStorage(t *testing.T) {
 	}
 
 	s.StorageGetName()
-	s.Read()
-	s.Save()
+	snapshot, err := s.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "3", snapshot.Version)
+	assert.NoError(t, s.Store(context.Background(), snapshot, snapshot.Version))
+
+	s.compat = true
+	assert.NoError(t, s.Store(context.Background(), snapshot, snapshot.Version))
 
 	auth.AssertExpectations(t)
 	system.AssertExpectations(t)
 	secrets.AssertExpectations(t)
 }
+
+// memVault is an in-memory KV v2 engine with check-and-set.
+type memVault struct {
+	mu      sync.Mutex
+	data    []byte
+	version int
+}
+
+func (m *memVault) TokenLookUpSelf(_ context.Context, _ ...vault.RequestOption) (*vault.Response[map[string]interface{}], error) {
+	return &vault.Response[map[string]interface{}]{}, nil
+}
+func (m *memVault) ReadHealthStatus(_ context.Context, _ ...vault.RequestOption) (*vault.Response[map[string]interface{}], error) {
+	return &vault.Response[map[string]interface{}]{}, nil
+}
+func (m *memVault) KvV2Read(_ context.Context, _ string, _ ...vault.RequestOption) (*vault.Response[schema.KvV2ReadResponse], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.data == nil {
+		return nil, &vault.ResponseError{StatusCode: http.StatusNotFound}
+	}
+
+	// the client decodes numbers as json.Number
+	data := map[string]interface{}{}
+	d := json.NewDecoder(bytes.NewReader(m.data))
+	d.UseNumber()
+	if err := d.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return &vault.Response[schema.KvV2ReadResponse]{
+		Data: schema.KvV2ReadResponse{
+			Data:     data,
+			Metadata: map[string]interface{}{"version": json.Number(strconv.Itoa(m.version))},
+		},
+	}, nil
+}
+func (m *memVault) KvV2Write(_ context.Context, _ string, request schema.KvV2WriteRequest, _ ...vault.RequestOption) (*vault.Response[schema.KvV2WriteResponse], error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cas, ok := request.Options["cas"]; ok && cas != m.version {
+		return nil, &vault.ResponseError{
+			StatusCode: http.StatusBadRequest,
+			Errors:     []string{"check-and-set parameter did not match the current version"},
+		}
+	}
+
+	data, err := json.Marshal(request.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	m.data = data
+	m.version++
+
+	return &vault.Response[schema.KvV2WriteResponse]{}, nil
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Backend {
+		m := &memVault{}
+
+		return storagetest.Backend{
+			Storage: &Storage{
+				auth:    m,
+				system:  m,
+				secrets: m,
+
+				name:       "vault",
+				secretName: "secret",
+				secretPath: "tokens-rotate",
+			},
+			Corrupt: func(t *testing.T) {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+
+				m.data = []byte(`{"access_token":"xoxe.xoxp-1","exp":"soon","refresh_token":"xoxe-1"}`)
+			},
+		}
+	})
+}
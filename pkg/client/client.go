@@ -0,0 +1,224 @@
+// Package client calls Slack with the token kept by the rotator. The token
+// is cached until it is close to expiry, and calls rejected with
+// invalid_auth or token_expired are retried once with a freshly fetched
+// token, so callers never handle rotation themselves.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/types"
+)
+
+type Options struct {
+	Source Source
+	// RefreshBefore is how long before expiry the cached token is fetched
+	// again, 5 minutes by default.
+	RefreshBefore time.Duration
+	// APIURL defaults to slack.APIURL.
+	APIURL     string
+	HTTPClient *http.Client
+	// SlackOptions are passed to every slack.Client.
+	SlackOptions []slack.Option
+	// Clock defaults to the system clock.
+	Clock Clock
+}
+
+// Clock tells the time the cached token is checked against, a rotator.Clock
+// is a Clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type Client struct {
+	mu    sync.Mutex
+	api   *slack.Client
+	token types.Token
+
+	apiURL        string
+	clock         Clock
+	httpClient    *http.Client
+	options       []slack.Option
+	refreshBefore time.Duration
+	source        Source
+}
+
+func New(opts Options) (*Client, error) {
+	if opts.Source == nil {
+		return nil, fmt.Errorf("token source is required")
+	}
+
+	c := &Client{
+		apiURL:        opts.APIURL,
+		clock:         opts.Clock,
+		httpClient:    opts.HTTPClient,
+		refreshBefore: opts.RefreshBefore,
+		source:        opts.Source,
+	}
+
+	if c.apiURL == "" {
+		c.apiURL = slack.APIURL
+	}
+
+	if c.clock == nil {
+		c.clock = systemClock{}
+	}
+
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{}
+	}
+
+	if c.refreshBefore <= 0 {
+		c.refreshBefore = 5 * time.Minute
+	}
+
+	c.options = append([]slack.Option{
+		slack.OptionAPIURL(c.apiURL),
+		slack.OptionHTTPClient(c.httpClient),
+	}, opts.SlackOptions...)
+
+	return c, nil
+}
+
+func (c *Client) fresh() bool {
+	if c.api == nil {
+		return false
+	}
+
+	if c.token.Exp == 0 {
+		return true
+	}
+
+	return c.clock.Now().Add(c.refreshBefore).Before(time.Unix(c.token.Exp, 0))
+}
+
+func (c *Client) current(ctx context.Context) (*slack.Client, types.Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.fresh() {
+		token, err := c.source.Token(ctx)
+		if err != nil {
+			return nil, types.Token{}, fmt.Errorf("failed to fetch token: %w", err)
+		}
+
+		c.token = token
+		c.api = slack.New(token.AccessToken.Reveal(), c.options...)
+	}
+
+	return c.api, c.token, nil
+}
+
+// Invalidate drops the cached token, the next call fetches it again.
+func (c *Client) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.api = nil
+}
+
+// retryable reports whether Slack rejected the token of a call, so the call
+// may pass with a rotated one.
+func retryable(err error) bool {
+	var res slack.SlackErrorResponse
+	if !errors.As(err, &res) {
+		return false
+	}
+
+	return res.Err == "invalid_auth" || res.Err == "token_expired"
+}
+
+func (c *Client) do(ctx context.Context, fn func(*slack.Client, types.Token) error) error {
+	api, token, err := c.current(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(api, token); !retryable(err) {
+		return err
+	}
+
+	c.Invalidate()
+
+	if api, token, err = c.current(ctx); err != nil {
+		return err
+	}
+
+	return fn(api, token)
+}
+
+// Do runs fn with a client holding the current token. fn runs again with a
+// re-fetched token when it fails with invalid_auth or token_expired, so it
+// must be safe to repeat.
+func (c *Client) Do(ctx context.Context, fn func(*slack.Client) error) error {
+	return c.do(ctx, func(api *slack.Client, _ types.Token) error {
+		return fn(api)
+	})
+}
+
+// Call posts params to a Web API method that slack-go has no wrapper for,
+// such as apps.manifest.*, and decodes the response into out.
+func (c *Client) Call(ctx context.Context, method string, params url.Values, out interface{}) error {
+	return c.do(ctx, func(_ *slack.Client, token types.Token) error {
+		req, err := http.NewRequestWithContext(
+			ctx,
+			http.MethodPost,
+			c.apiURL+method,
+			strings.NewReader(params.Encode()),
+		)
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken.Reveal())
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		res, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %s", method, res.Status)
+		}
+
+		status := slack.SlackResponse{}
+		if err := json.Unmarshal(data, &status); err != nil {
+			return err
+		}
+
+		if !status.Ok {
+			return slack.SlackErrorResponse{Err: status.Error}
+		}
+
+		if out == nil {
+			return nil
+		}
+
+		return json.NewDecoder(bytes.NewReader(data)).Decode(out)
+	})
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slack-utils/tokens-rotate/internal/server"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/rotatortest"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/types"
+)
+
+type fakeSource struct {
+	tokens  []types.Token
+	fetched int
+}
+
+func (f *fakeSource) Token(_ context.Context) (types.Token, error) {
+	if f.fetched >= len(f.tokens) {
+		return types.Token{}, fmt.Errorf("no more tokens")
+	}
+
+	f.fetched++
+
+	return f.tokens[f.fetched-1], nil
+}
+
+// newSlack accepts only the given access token.
+func newSlack(t *testing.T, valid string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.Form.Get("token")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if token != valid {
+			fmt.Fprint(w, `{"ok":false,"error":"invalid_auth"}`)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/auth.test":
+			fmt.Fprint(w, `{"ok":true,"user_id":"U1"}`)
+		case "/apps.manifest.validate":
+			fmt.Fprintf(w, `{"ok":true,"app_id":%q}`, r.Form.Get("app_id"))
+		default:
+			fmt.Fprint(w, `{"ok":false,"error":"unknown_method"}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestClient(t *testing.T) {
+	now := time.Unix(10000, 0)
+
+	tests := []struct {
+		name    string
+		tokens  []types.Token
+		calls   int
+		fetched int
+		error   bool
+	}{
+		{
+			name:    "cached token",
+			tokens:  []types.Token{{AccessToken: "valid", Exp: now.Add(time.Hour).Unix()}},
+			calls:   3,
+			fetched: 1,
+		},
+		{
+			name: "token close to expiry",
+			tokens: []types.Token{
+				{AccessToken: "valid", Exp: now.Add(time.Minute).Unix()},
+				{AccessToken: "valid", Exp: now.Add(time.Minute).Unix()},
+			},
+			calls:   2,
+			fetched: 2,
+		},
+		{
+			name: "rotated token",
+			tokens: []types.Token{
+				{AccessToken: "old"},
+				{AccessToken: "valid"},
+			},
+			calls:   2,
+			fetched: 2,
+		},
+		{
+			name: "retried once",
+			tokens: []types.Token{
+				{AccessToken: "old"},
+				{AccessToken: "older"},
+				{AccessToken: "valid"},
+			},
+			calls:   1,
+			fetched: 2,
+			error:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSlack(t, "valid")
+			source := &fakeSource{tokens: tt.tokens}
+
+			c, err := New(Options{
+				Source: source,
+				APIURL: srv.URL + "/",
+				Clock:  rotatortest.NewClock(now),
+			})
+			assert.NoError(t, err)
+
+			for i := 0; i < tt.calls; i++ {
+				err := c.Do(context.Background(), func(api *slack.Client) error {
+					_, err := api.AuthTestContext(context.Background())
+					return err
+				})
+
+				if tt.error {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+				}
+			}
+
+			assert.Equal(t, tt.fetched, source.fetched)
+		})
+	}
+}
+
+func TestCall(t *testing.T) {
+	srv := newSlack(t, "valid")
+	source := &fakeSource{tokens: []types.Token{{AccessToken: "old"}, {AccessToken: "valid"}}}
+
+	c, err := New(Options{Source: source, APIURL: srv.URL + "/"})
+	assert.NoError(t, err)
+
+	res := struct {
+		AppID string `json:"app_id"`
+	}{}
+	assert.NoError(t, c.Call(context.Background(), "apps.manifest.validate", url.Values{"app_id": {"A1"}}, &res))
+	assert.Equal(t, "A1", res.AppID)
+	assert.Equal(t, 2, source.fetched)
+
+	assert.EqualError(t, c.Call(context.Background(), "apps.unknown", nil, nil), "unknown_method")
+}
+
+type appState struct {
+	token types.Token
+}
+
+func (a appState) Token() types.Token {
+	return a.token
+}
+func (a appState) Rotate(_ context.Context) (types.Token, error) {
+	return a.token, nil
+}
+
+func TestFromSidecar(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "bearer")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("secret"), 0600))
+
+	token := types.Token{AccessToken: "xoxe.xoxp-1-access", Exp: 123}
+
+	s, err := server.New(appState{token: token}, server.Options{Address: "127.0.0.1:0", TokenFile: tokenFile})
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(s.Handler(false))
+	defer srv.Close()
+
+	source, err := FromSidecar(SidecarOptions{URL: srv.URL, TokenFile: tokenFile})
+	assert.NoError(t, err)
+
+	got, err := source.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, token, got)
+
+	source, err = FromSidecar(SidecarOptions{URL: srv.URL})
+	assert.NoError(t, err)
+
+	_, err = source.Token(context.Background())
+	assert.Error(t, err)
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "invalid auth", err: slack.SlackErrorResponse{Err: "invalid_auth"}, retryable: true},
+		{name: "expired token", err: slack.SlackErrorResponse{Err: "token_expired"}, retryable: true},
+		{name: "wrapped", err: fmt.Errorf("call failed: %w", slack.SlackErrorResponse{Err: "invalid_auth"}), retryable: true},
+		{name: "other error", err: slack.SlackErrorResponse{Err: "channel_not_found"}},
+		{name: "not from slack", err: fmt.Errorf("invalid_auth")},
+		{name: "no error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.retryable, retryable(tt.err))
+		})
+	}
+}
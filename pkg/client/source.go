@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/slack-utils/tokens-rotate/internal/server"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/types"
+)
+
+// Source returns the current token.
+type Source interface {
+	Token(context.Context) (types.Token, error)
+}
+
+type storageSource struct {
+	storage types.Loader
+}
+
+// FromStorage reads the token from any storage of the rotator.
+func FromStorage(s types.Loader) Source {
+	return &storageSource{storage: s}
+}
+
+func (s *storageSource) Token(ctx context.Context) (types.Token, error) {
+	snapshot, err := s.storage.Load(ctx)
+	if err != nil {
+		return types.Token{}, err
+	}
+
+	return snapshot.Token, nil
+}
+
+type SidecarOptions struct {
+	// URL of the API, for example http://127.0.0.1:8443. It defaults to
+	// http://localhost when Socket is set.
+	URL string
+	// Socket is the path of the Unix socket of the API.
+	Socket string
+	// TokenFile holds the bearer token of the API.
+	TokenFile string
+	// HTTPClient is used for TCP connections, mTLS is configured on it.
+	HTTPClient *http.Client
+}
+
+type sidecarSource struct {
+	bearer string
+	client *http.Client
+	url    string
+}
+
+// FromSidecar reads the token from the local API of `refresh --serve`.
+func FromSidecar(opts SidecarOptions) (Source, error) {
+	s := &sidecarSource{
+		client: opts.HTTPClient,
+		url:    strings.TrimSuffix(opts.URL, "/"),
+	}
+
+	if s.client == nil {
+		s.client = &http.Client{}
+	}
+
+	if opts.Socket != "" {
+		s.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", opts.Socket)
+				},
+			},
+		}
+
+		if s.url == "" {
+			s.url = "http://localhost"
+		}
+	}
+
+	if s.url == "" {
+		return nil, fmt.Errorf("url or socket is required")
+	}
+
+	if opts.TokenFile != "" {
+		data, err := os.ReadFile(opts.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token: %w", err)
+		}
+
+		s.bearer = strings.TrimSpace(string(data))
+	}
+
+	return s, nil
+}
+
+func (s *sidecarSource) Token(ctx context.Context) (types.Token, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/v1/token", nil)
+	if err != nil {
+		return types.Token{}, err
+	}
+
+	if s.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+s.bearer)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return types.Token{}, err
+	}
+	defer res.Body.Close()
+
+	body := server.Response{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return types.Token{}, fmt.Errorf("invalid response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return types.Token{}, fmt.Errorf("sidecar returned %s: %s", res.Status, body.Error)
+	}
+
+	return types.Token{
+		AccessToken: types.Secret(body.AccessToken),
+		Exp:         body.Exp,
+		Kind:        body.Kind,
+	}, nil
+}
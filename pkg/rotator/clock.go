@@ -0,0 +1,20 @@
+package rotator
+
+import "time"
+
+// Clock is the source of time for App, it lets tests run rotations
+// without real waits.
+type Clock interface {
+	After(time.Duration) <-chan time.Time
+	Now() time.Time
+}
+
+type SystemClock struct{}
+
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
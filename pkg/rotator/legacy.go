@@ -0,0 +1,76 @@
+package rotator
+
+import (
+	"context"
+	"fmt"
+)
+
+// LegacyStorage is the getter/setter storage interface, implementations
+// usually embed shared.GeneralStorage.
+type LegacyStorage interface {
+	Read(context.Context) error
+	Save(context.Context) error
+	StorageGetName() string
+	TokenGetAccess() string
+	TokenGetExpirationTime() int64
+	TokenGetMovedTo() string
+	TokenGetRefresh() string
+	TokenSetAccess(string)
+	TokenSetExpirationTime(int64)
+	TokenSetMovedTo(string)
+	TokenSetRefresh(string)
+}
+
+// LegacyKinder is implemented by legacy storages that keep the kind of the
+// token, shared.GeneralStorage does.
+type LegacyKinder interface {
+	TokenGetKind() string
+	TokenSetKind(string)
+}
+
+type legacy struct {
+	LegacyStorage
+}
+
+// FromLegacy adapts a LegacyStorage to Storage. Legacy storages have no
+// versions, so their writes never fail with ErrVersionConflict. The kind of
+// the token is kept only by storages implementing LegacyKinder, others
+// refuse to store oauth tokens, which would be rotated as configuration
+// tokens once read back.
+func FromLegacy(s LegacyStorage) Storage {
+	return &legacy{LegacyStorage: s}
+}
+
+func (l *legacy) Load(ctx context.Context) (Snapshot, error) {
+	if err := l.Read(ctx); err != nil {
+		return Snapshot{}, err
+	}
+
+	token := Token{
+		AccessToken:  Secret(l.TokenGetAccess()),
+		Exp:          l.TokenGetExpirationTime(),
+		MovedTo:      l.TokenGetMovedTo(),
+		RefreshToken: Secret(l.TokenGetRefresh()),
+	}
+
+	if k, ok := l.LegacyStorage.(LegacyKinder); ok {
+		token.Kind = k.TokenGetKind()
+	}
+
+	return Snapshot{Token: token}, nil
+}
+
+func (l *legacy) Store(ctx context.Context, snapshot Snapshot, _ string) error {
+	if k, ok := l.LegacyStorage.(LegacyKinder); ok {
+		k.TokenSetKind(snapshot.Kind)
+	} else if snapshot.Kind == KindOAuth {
+		return fmt.Errorf("%s storage can't keep the kind of %s tokens", l.StorageGetName(), KindOAuth)
+	}
+
+	l.TokenSetAccess(snapshot.AccessToken.Reveal())
+	l.TokenSetExpirationTime(snapshot.Exp)
+	l.TokenSetMovedTo(snapshot.MovedTo)
+	l.TokenSetRefresh(snapshot.RefreshToken.Reveal())
+
+	return l.Save(ctx)
+}
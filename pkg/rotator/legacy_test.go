@@ -0,0 +1,66 @@
+package rotator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+type legacyStorage struct {
+	shared.GeneralStorage
+
+	saved int
+}
+
+func (l *legacyStorage) Read(_ context.Context) error {
+	return nil
+}
+func (l *legacyStorage) Save(_ context.Context) error {
+	l.saved++
+	return nil
+}
+func (l *legacyStorage) StorageGetName() string {
+	return "legacy"
+}
+
+// kindlessStorage is a legacy storage written before token kinds.
+type kindlessStorage struct {
+	LegacyStorage
+}
+
+func TestFromLegacy(t *testing.T) {
+	l := &legacyStorage{}
+	s := FromLegacy(l)
+
+	token := Token{
+		AccessToken:  "test-access-token",
+		Exp:          123,
+		Kind:         KindOAuth,
+		RefreshToken: "test-refresh-token",
+	}
+
+	assert.NoError(t, s.Store(context.Background(), Snapshot{Token: token}, "ignored"))
+	assert.Equal(t, 1, l.saved)
+
+	snapshot, err := s.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Snapshot{Token: token}, snapshot)
+
+	// a storage without the kind keeps configuration tokens only
+	kindless := FromLegacy(kindlessStorage{LegacyStorage: l})
+
+	assert.Error(t, kindless.Store(context.Background(), Snapshot{Token: token}, ""))
+	assert.Equal(t, 1, l.saved)
+
+	token.Kind = KindConfig
+	assert.NoError(t, kindless.Store(context.Background(), Snapshot{Token: token}, ""))
+	assert.Equal(t, 2, l.saved)
+
+	snapshot, err = kindless.Load(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "test-access-token", snapshot.AccessToken.Reveal())
+	assert.Empty(t, snapshot.Kind)
+}
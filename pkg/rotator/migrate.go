@@ -0,0 +1,65 @@
+package rotator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Migrate copies the token from src to dst. The token is verified with
+// Slack before writing and read back from dst after writing. With tombstone
+// the source is marked as moved, so a daemon started on it refuses to rotate
+// the same chain.
+func Migrate(ctx context.Context, src, dst Storage, factory SlackClientFactory, tombstone bool) error {
+	snapshot, err := src.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read %s storage: %w", src.StorageGetName(), err)
+	}
+
+	if to := snapshot.MovedTo; to != "" {
+		return fmt.Errorf("tokens were already migrated to %s storage", to)
+	}
+
+	log.Info("verifying access token")
+	if _, err := factory(snapshot.AccessToken.Reveal()).AuthTestContext(ctx); err != nil {
+		return fmt.Errorf("failed to verify access token: %w", err)
+	}
+
+	prev, err := dst.Load(ctx)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to read %s storage: %w", dst.StorageGetName(), err)
+	}
+
+	log.Infof("saving token to %s storage", dst.StorageGetName())
+	if err := dst.Store(ctx, Snapshot{Token: snapshot.Token}, prev.Version); err != nil {
+		return fmt.Errorf("failed to save %s storage: %w", dst.StorageGetName(), err)
+	}
+
+	stored, err := dst.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read back %s storage: %w", dst.StorageGetName(), err)
+	}
+
+	if stored.Token != snapshot.Token {
+		return fmt.Errorf("token read back from %s storage doesn't match", dst.StorageGetName())
+	}
+
+	if !tombstone {
+		return nil
+	}
+
+	log.Infof("marking %s storage as moved", src.StorageGetName())
+
+	if err := src.Store(ctx, Snapshot{
+		Token: Token{
+			Exp:     snapshot.Exp,
+			MovedTo: dst.StorageGetName(),
+		},
+	}, snapshot.Version); err != nil {
+		return fmt.Errorf("failed to tombstone %s storage: %w", src.StorageGetName(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package rotator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+type memStorage struct {
+	name    string
+	stored  *Token
+	version int
+}
+
+func (m *memStorage) Load(_ context.Context) (Snapshot, error) {
+	if m.stored == nil {
+		return Snapshot{}, ErrNotFound
+	}
+
+	return Snapshot{Token: *m.stored, Version: fmt.Sprint(m.version)}, nil
+}
+func (m *memStorage) Store(_ context.Context, snapshot Snapshot, prevVersion string) error {
+	if m.stored != nil && prevVersion != fmt.Sprint(m.version) || m.stored == nil && prevVersion != "" {
+		return ErrVersionConflict
+	}
+
+	token := snapshot.Token
+	m.stored = &token
+	m.version++
+
+	return nil
+}
+func (m *memStorage) StorageGetName() string {
+	return m.name
+}
+
+func TestMigrate(t *testing.T) {
+	token := Token{
+		AccessToken:  "access-token",
+		Exp:          123,
+		RefreshToken: "refresh-token",
+	}
+
+	tests := []struct {
+		name      string
+		source    *Token
+		authErr   error
+		tombstone bool
+		err       bool
+		expected  *Token
+	}{
+		{
+			name:     "copy",
+			source:   &token,
+			expected: &token,
+		},
+		{
+			name:      "tombstone",
+			source:    &token,
+			tombstone: true,
+			expected:  &Token{Exp: 123, MovedTo: "dst"},
+		},
+		{
+			name:     "invalid token",
+			source:   &token,
+			authErr:  fmt.Errorf("invalid_auth"),
+			err:      true,
+			expected: &token,
+		},
+		{
+			name:     "already migrated",
+			source:   &Token{MovedTo: "other"},
+			err:      true,
+			expected: &Token{MovedTo: "other"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := *tt.source
+			src := &memStorage{name: "src", stored: &source, version: 1}
+			dst := &memStorage{name: "dst"}
+			c := &SlackMock{}
+			c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, tt.authErr)
+
+			err := Migrate(context.Background(), src, dst, func(_ string, _ ...slack.Option) SlackClient {
+				return c
+			}, tt.tombstone)
+
+			if tt.err {
+				assert.Error(t, err)
+				assert.Nil(t, dst.stored)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, &token, dst.stored)
+			}
+
+			assert.Equal(t, tt.expected, src.stored)
+		})
+	}
+}
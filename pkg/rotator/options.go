@@ -0,0 +1,99 @@
+package rotator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/slack-go/slack"
+
+	"github.com/slack-utils/tokens-rotate/internal/audit"
+	"github.com/slack-utils/tokens-rotate/internal/hook"
+	"github.com/slack-utils/tokens-rotate/internal/journal"
+	"github.com/slack-utils/tokens-rotate/internal/notify"
+	"github.com/slack-utils/tokens-rotate/internal/redact"
+	"github.com/slack-utils/tokens-rotate/internal/server"
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+type (
+	Revision = shared.Revision
+	Secret   = shared.Secret
+	Snapshot = shared.Snapshot
+	Token    = shared.Token
+
+	AuditLog       = audit.Log
+	AuditOptions   = audit.Options
+	HookConfig     = hook.Config
+	Hooks          = hook.Runner
+	Journal        = journal.Journal
+	JournalOptions = journal.Options
+	Notifier       = notify.Dispatcher
+	NotifyOptions  = notify.Options
+	Server         = server.Server
+	ServerOptions  = server.Options
+)
+
+const (
+	KindConfig = shared.KindConfig
+	KindOAuth  = shared.KindOAuth
+)
+
+var (
+	ErrNotFound        = shared.ErrNotFound
+	ErrNotLeader       = shared.ErrNotLeader
+	ErrTokenRejected   = shared.ErrTokenRejected
+	ErrVersionConflict = shared.ErrVersionConflict
+)
+
+// rejections are the auth.test errors telling that a token is not valid,
+// other failures may pass on retry.
+var rejections = map[string]bool{
+	"account_inactive": true,
+	"invalid_auth":     true,
+	"not_authed":       true,
+	"token_expired":    true,
+	"token_revoked":    true,
+}
+
+// VerifyAuthTest returns a check calling auth.test with the access token of
+// a token. Errors of a token Slack rejected wrap ErrTokenRejected.
+func VerifyAuthTest(factory SlackClientFactory) func(context.Context, Token) error {
+	return func(ctx context.Context, token Token) error {
+		_, err := factory(token.AccessToken.Reveal()).AuthTestContext(ctx)
+
+		var res slack.SlackErrorResponse
+		if errors.As(err, &res) && rejections[res.Err] {
+			return fmt.Errorf("%w: %s", ErrTokenRejected, res.Err)
+		}
+
+		return err
+	}
+}
+
+// NewRedactHook returns a logrus hook scrubbing Slack tokens from log
+// entries.
+func NewRedactHook() *redact.Hook {
+	return redact.NewHook()
+}
+
+// NewServer returns the local API serving the token held by app.
+func NewServer(app *App, opts ServerOptions) (*Server, error) {
+	return server.New(app, opts)
+}
+
+func NewAuditLog(opts AuditOptions) (*AuditLog, error) {
+	return audit.New(opts)
+}
+
+func NewHooks(configs []HookConfig) (*Hooks, error) {
+	return hook.New(configs)
+}
+
+func NewJournal(opts JournalOptions) (*Journal, error) {
+	return journal.New(opts)
+}
+
+func NewNotifier(opts NotifyOptions) *Notifier {
+	return notify.New(opts)
+}
@@ -0,0 +1,927 @@
+//go:generate mockgen -source=${GOFILE} -destination=mock/${GOFILE}
+package rotator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/slack-utils/tokens-rotate/internal/audit"
+	"github.com/slack-utils/tokens-rotate/internal/hook"
+	"github.com/slack-utils/tokens-rotate/internal/journal"
+	"github.com/slack-utils/tokens-rotate/internal/notify"
+)
+
+// Storage keeps token snapshots. Store must fail with ErrVersionConflict
+// when the stored version is no longer prevVersion, an empty prevVersion
+// means that nothing is expected to be stored yet.
+type Storage interface {
+	Load(context.Context) (Snapshot, error)
+	Store(ctx context.Context, snapshot Snapshot, prevVersion string) error
+	StorageGetName() string
+}
+
+// Locker is implemented by storages that elect a single daemon to rotate
+// the token. Check and Rotate only rotate while the lock is held, other
+// daemons reload the stored token instead.
+type Locker interface {
+	// Lock reports whether this process holds the lock, acquiring it if it
+	// is free. It doesn't wait for the lock.
+	Lock(context.Context) (bool, error)
+	// Unlock releases the lock if it is held.
+	Unlock(context.Context) error
+}
+
+// Watcher is implemented by storages that report tokens stored by other
+// processes, so a daemon without the rotation lock picks them up right
+// away instead of on its next check.
+type Watcher interface {
+	// Watch sends the snapshots stored after the call until the context is
+	// done, then closes the channel.
+	Watch(context.Context) <-chan Snapshot
+}
+
+// Historian is implemented by storages that keep every saved token.
+type Historian interface {
+	// History lists the saved tokens, the latest first.
+	History(context.Context) ([]Revision, error)
+}
+
+type SlackClient interface {
+	AuthTestContext(context.Context) (*slack.AuthTestResponse, error)
+	ToolingTokensRotateContext(ctx context.Context, refresh_token string) (*slack.ToolingTokensRotate, error)
+}
+
+type SlackClientFactory func(string, ...slack.Option) SlackClient
+
+// NewSlackClient is the SlackClientFactory backed by slack-go.
+func NewSlackClient(token string, options ...slack.Option) SlackClient {
+	return slack.New(token, options...)
+}
+
+// OAuthRefreshFunc exchanges the refresh token of a bot or user token for a
+// new token pair through oauth.v2.access.
+type OAuthRefreshFunc func(ctx context.Context, clientID, clientSecret, refreshToken string) (*slack.OAuthV2Response, error)
+
+// RefreshOAuthToken is the OAuthRefreshFunc backed by slack-go.
+func RefreshOAuthToken(ctx context.Context, clientID, clientSecret, refreshToken string) (*slack.OAuthV2Response, error) {
+	return slack.RefreshOAuthV2TokenContext(ctx, http.DefaultClient, clientID, clientSecret, refreshToken)
+}
+
+// ErrMoved is returned once migrate marked the storage as moved, the token
+// chain belongs to the daemons of the new storage then.
+var ErrMoved = errors.New("tokens were migrated to another storage")
+
+var (
+	rotateRetryDelay = time.Second
+	rotateRetryLimit = 3
+	rotateTimeout    = 5 * time.Minute
+	saveBackoff      = time.Second
+	saveRetryLimit   = 5
+)
+
+type Options struct {
+	Storage            Storage
+	SlackClientFactory SlackClientFactory
+	// Logger defaults to the standard logrus logger.
+	Logger log.FieldLogger
+	// Clock defaults to the system clock.
+	Clock Clock
+
+	// SlackTimeout and StorageTimeout limit every single call to Slack and
+	// to the storage, zero means no limit.
+	SlackTimeout   time.Duration
+	StorageTimeout time.Duration
+
+	// Fallback tokens are used when the storage can't be read or the stored
+	// refresh token was rejected. Their kind is also used for stored tokens
+	// without one.
+	Fallback Token
+
+	// OAuthClientID and OAuthClientSecret are the app credentials required
+	// to refresh tokens of the oauth kind. OAuthRefresh defaults to
+	// RefreshOAuthToken.
+	OAuthClientID     string
+	OAuthClientSecret Secret
+	OAuthRefresh      OAuthRefreshFunc
+
+	Audit    *AuditLog
+	Hooks    *Hooks
+	Journal  *Journal
+	Notifier *Notifier
+}
+
+type App struct {
+	SlackClient
+
+	// run serializes checks and rotations, state guards current and pending
+	// for readers outside of them.
+	run   sync.Mutex
+	state sync.RWMutex
+	group singleflight.Group
+
+	// current is the snapshot known to be stored, pending is a rotated
+	// token the storage hasn't accepted yet.
+	current Snapshot
+	pending *Token
+	storage Storage
+	locker  Locker
+	watcher Watcher
+
+	audit    *audit.Log
+	clock    Clock
+	factory  SlackClientFactory
+	fallback Token
+	hooks    *hook.Runner
+	log      log.FieldLogger
+	notifier *notify.Dispatcher
+
+	oauthClientID     string
+	oauthClientSecret Secret
+	oauthRefresh      OAuthRefreshFunc
+
+	slackTimeout   time.Duration
+	storageTimeout time.Duration
+}
+
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, d)
+}
+
+func (a *App) authTest(ctx context.Context) (*slack.AuthTestResponse, error) {
+	ctx, cancel := withTimeout(ctx, a.slackTimeout)
+	defer cancel()
+
+	return a.AuthTestContext(ctx)
+}
+
+// kind returns the kind of token, tokens stored without one have the kind
+// of the fallback tokens.
+func (a *App) kind(token Token) string {
+	if token.Kind != "" {
+		return token.Kind
+	}
+
+	if a.fallback.Kind != "" {
+		return a.fallback.Kind
+	}
+
+	return KindConfig
+}
+
+// rotate exchanges the refresh token of token for a new token pair of the
+// same kind.
+func (a *App) rotate(ctx context.Context, token Token) (Token, error) {
+	ctx, cancel := withTimeout(ctx, a.slackTimeout)
+	defer cancel()
+
+	kind := a.kind(token)
+
+	if kind == KindOAuth {
+		res, err := a.oauthRefresh(ctx, a.oauthClientID, a.oauthClientSecret.Reveal(), token.RefreshToken.Reveal())
+		if err != nil {
+			return Token{}, err
+		}
+
+		return Token{
+			AccessToken:  Secret(res.AccessToken),
+			Exp:          a.clock.Now().Add(time.Duration(res.ExpiresIn) * time.Second).Unix(),
+			Kind:         kind,
+			RefreshToken: Secret(res.RefreshToken),
+		}, nil
+	}
+
+	res, err := a.ToolingTokensRotateContext(ctx, token.RefreshToken.Reveal())
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{
+		AccessToken:  Secret(res.Token),
+		Exp:          res.Exp,
+		Kind:         token.Kind,
+		RefreshToken: Secret(res.RefreshToken),
+	}, nil
+}
+
+func (a *App) load(ctx context.Context) (Snapshot, error) {
+	ctx, cancel := withTimeout(ctx, a.storageTimeout)
+	defer cancel()
+
+	return a.storage.Load(ctx)
+}
+
+func (a *App) store(ctx context.Context, token Token) error {
+	ctx, cancel := withTimeout(ctx, a.storageTimeout)
+	defer cancel()
+
+	return a.storage.Store(ctx, Snapshot{Token: token}, a.current.Version)
+}
+
+// Token returns the token in use, it may be not stored yet. It is safe to
+// call while Run is working.
+func (a *App) Token() Token {
+	a.state.RLock()
+	defer a.state.RUnlock()
+
+	return a.token()
+}
+
+// Rotate forces a rotation and returns the new token. Concurrent calls are
+// coalesced into a single rotation, which also waits for a check in progress.
+// The rotation is detached from the callers, a caller giving up stops only
+// its own wait and the rotated token is still saved.
+func (a *App) Rotate(ctx context.Context) (Token, error) {
+	ch := a.group.DoChan("rotate", func() (any, error) {
+		ctx, cancel := withTimeout(detached{ctx}, rotateTimeout)
+		defer cancel()
+
+		a.run.Lock()
+		defer a.run.Unlock()
+
+		leader, err := a.leader(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if !leader {
+			return nil, ErrNotLeader
+		}
+
+		if err := a.checkMoved(ctx); err != nil {
+			return nil, err
+		}
+
+		return nil, a.tokenRotate(ctx)
+	})
+	a.log.Debug("waiting for token rotation")
+
+	select {
+	case <-ctx.Done():
+		return a.Token(), ctx.Err()
+	case res := <-ch:
+		return a.Token(), res.Err
+	}
+}
+
+// detached keeps the values of a context but not its cancellation, like
+// context.WithoutCancel of newer Go releases.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+// Stored reports whether the token in use was accepted by the storage.
+func (a *App) Stored() bool {
+	a.state.RLock()
+	defer a.state.RUnlock()
+
+	return a.pending == nil
+}
+
+// leader reports whether this daemon may rotate, which is always the case
+// without a Locker.
+func (a *App) leader(ctx context.Context) (bool, error) {
+	if a.locker == nil {
+		return true, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, a.storageTimeout)
+	defer cancel()
+
+	leader, err := a.locker.Lock(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire rotation lock: %w", err)
+	}
+
+	return leader, nil
+}
+
+// follow replaces the token in use with the stored one, which is kept
+// fresh by the daemon holding the lock. It fails only when the storage was
+// marked as moved.
+func (a *App) follow(ctx context.Context) error {
+	snapshot, err := a.load(ctx)
+	if err != nil {
+		a.log.WithField("err", err).Error("failed to reload token")
+
+		return nil
+	}
+
+	if err := a.moved(snapshot); err != nil {
+		return err
+	}
+
+	a.state.Lock()
+	a.current = snapshot
+	a.pending = nil
+	a.state.Unlock()
+
+	a.SlackClient = a.factory(a.token().AccessToken.Reveal())
+
+	return nil
+}
+
+// moved fails when snapshot is the tombstone left by migrate.
+func (a *App) moved(snapshot Snapshot) error {
+	if snapshot.MovedTo == "" {
+		return nil
+	}
+
+	return fmt.Errorf("%w: from %s to %s storage", ErrMoved, a.storage.StorageGetName(), snapshot.MovedTo)
+}
+
+// checkMoved reads the stored token before a rotation, migrate may have
+// marked the storage as moved while the daemon was running. A failed read
+// is left to the store following the rotation.
+func (a *App) checkMoved(ctx context.Context) error {
+	snapshot, err := a.load(ctx)
+	if err != nil {
+		return nil
+	}
+
+	return a.moved(snapshot)
+}
+
+// watch takes over the snapshots stored by other processes until the
+// context is done. A pending token is kept, it was rotated last. A tombstone
+// is sent to moved and ends the watch.
+func (a *App) watch(ctx context.Context, moved chan<- error) {
+	for snapshot := range a.watcher.Watch(ctx) {
+		if err := a.moved(snapshot); err != nil {
+			moved <- err
+
+			return
+		}
+
+		a.run.Lock()
+
+		if a.pending == nil && snapshot.Version != a.current.Version {
+			a.log.WithField("version", snapshot.Version).Debug("stored token was changed, reloading")
+
+			a.state.Lock()
+			a.current = snapshot
+			a.state.Unlock()
+
+			a.SlackClient = a.factory(a.current.AccessToken.Reveal())
+		}
+
+		a.run.Unlock()
+	}
+}
+
+func (a *App) token() Token {
+	if a.pending != nil {
+		return *a.pending
+	}
+
+	return a.current.Token
+}
+
+func (a *App) emit(kind string, err error) {
+	e := audit.Event{
+		Time:        a.clock.Now().UTC(),
+		Type:        kind,
+		Storage:     a.storage.StorageGetName(),
+		Fingerprint: audit.Fingerprint(a.token().AccessToken.Reveal()),
+		Exp:         a.token().Exp,
+	}
+
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	a.audit.Emit(e)
+}
+
+func (a *App) notify(ctx context.Context, kind string, err error) {
+	n := notify.Notification{
+		Time:    a.clock.Now().UTC(),
+		Kind:    kind,
+		Storage: a.storage.StorageGetName(),
+		Exp:     a.token().Exp,
+	}
+
+	if err != nil {
+		n.Error = err.Error()
+	}
+
+	a.notifier.Notify(ctx, n)
+}
+
+// notifyRotateFailed reports a broken chain only once the fallback token
+// was rejected too, or when there is no fallback token to try.
+func (a *App) notifyRotateFailed(ctx context.Context, err error, fallback bool) {
+	if strings.Contains(err.Error(), "invalid_refresh_token") && (fallback || a.fallback.RefreshToken == "") {
+		a.notify(ctx, notify.ChainBroken, err)
+	} else {
+		a.notify(ctx, notify.RotationFailed, err)
+	}
+}
+
+func (a *App) sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-a.clock.After(d):
+		return nil
+	}
+}
+
+func (a *App) fallbackToken() Token {
+	return Token{
+		AccessToken:  a.fallback.AccessToken,
+		Kind:         a.fallback.Kind,
+		RefreshToken: a.fallback.RefreshToken,
+		Exp: a.clock.
+			Now().
+			Add(time.Hour * time.Duration(12)).
+			Unix(),
+	}
+}
+
+func (a *App) tokenRotate(ctx context.Context) error {
+	a.log.WithField("kind", a.kind(a.token())).Info("rotating token")
+	a.emit(audit.RotateAttempted, nil)
+
+	token, err := a.rotate(ctx, a.token())
+	if err != nil {
+		a.log.WithField("err", err).Error("failed to rotate token")
+		a.emit(audit.RotateFailed, err)
+		a.notifyRotateFailed(ctx, err, false)
+
+		a.log.Info("using fallback tokens")
+		a.emit(audit.EnvFallback, nil)
+		retry_limit := rotateRetryLimit
+
+		for {
+			if err := a.sleep(ctx, rotateRetryDelay); err != nil {
+				return err
+			}
+
+			a.emit(audit.RotateAttempted, nil)
+			if token, err = a.rotate(ctx, a.fallbackToken()); err == nil {
+				break
+			}
+
+			a.log.WithField("err", err).Error("failed to rotate token")
+			a.emit(audit.RotateFailed, err)
+			a.notifyRotateFailed(ctx, err, true)
+
+			retry_limit--
+
+			if retry_limit < 1 {
+				return fmt.Errorf("failed to rotate token: %w", err)
+			}
+		}
+	}
+
+	a.state.Lock()
+	a.pending = &token
+	a.state.Unlock()
+
+	a.emit(audit.RotateSucceeded, nil)
+	a.SlackClient = a.factory(a.pending.AccessToken.Reveal())
+
+	a.log.Info("saving new token")
+	if err := a.flush(ctx); errors.Is(err, ErrMoved) {
+		return err
+	} else if err != nil {
+		a.log.WithField("err", err).Error("failed to save token")
+		a.emit(audit.SaveFailed, err)
+		a.notify(ctx, notify.SaveFailed, err)
+
+		return nil
+	}
+	a.emit(audit.SaveSucceeded, nil)
+
+	a.runHooks(ctx)
+
+	return nil
+}
+
+// runHooks reports failed hooks but never rolls back the rotated token.
+func (a *App) runHooks(ctx context.Context) {
+	token := a.token()
+	token.Kind = a.kind(token)
+
+	results := a.hooks.Run(ctx, token)
+
+	for _, r := range results {
+		if r.Err != nil {
+			err := fmt.Errorf("%s: %w", r.Name, r.Err)
+
+			a.log.WithField("err", err).Error("hook was failed")
+			a.emit(audit.HookFailed, err)
+			a.notify(ctx, notify.HookFailed, err)
+
+			continue
+		}
+
+		a.emit(audit.HookSucceeded, nil)
+	}
+}
+
+// flush stores the pending token, retrying with backoff. The pending token
+// is kept on failure and stored again on the next check, it is dropped when
+// a later rotation was stored concurrently.
+func (a *App) flush(ctx context.Context) (err error) {
+	if a.pending == nil {
+		return nil
+	}
+
+	delay := saveBackoff
+
+	for attempt := 1; ; attempt++ {
+		if err = a.store(ctx, *a.pending); err == nil {
+			break
+		}
+
+		// the next check follows the daemon holding the lock now
+		if errors.Is(err, ErrNotLeader) {
+			return err
+		}
+
+		if errors.Is(err, ErrVersionConflict) {
+			older, err := a.resolveConflict(ctx)
+			if err != nil || a.pending == nil {
+				return err
+			}
+
+			// the pending token is stored over the older one right away
+			if older && attempt < saveRetryLimit {
+				continue
+			}
+		}
+
+		if attempt >= saveRetryLimit {
+			return err
+		}
+
+		a.log.WithField("err", err).Warnf("failed to save token, retrying in %s", delay)
+
+		if err := a.sleep(ctx, delay); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+
+	stored := Snapshot{Token: *a.pending}
+	snapshot, err := a.load(ctx)
+	if err == nil && snapshot.MovedTo == "" {
+		stored = snapshot
+	} else if err != nil {
+		a.log.WithField("err", err).Warn("failed to read back saved token")
+	}
+
+	a.state.Lock()
+	a.current = stored
+	a.pending = nil
+	a.state.Unlock()
+
+	// the token was saved before migrate marked the storage as moved
+	return a.moved(snapshot)
+}
+
+// resolveConflict reads the token stored concurrently with the pending one.
+// A token stored by a later rotation is adopted and the pending token is
+// dropped, otherwise it reports whether the pending token may be stored over
+// the older one.
+func (a *App) resolveConflict(ctx context.Context) (bool, error) {
+	snapshot, err := a.load(ctx)
+	if err != nil {
+		a.log.WithField("err", err).Warn("failed to read concurrently stored token")
+
+		return false, nil
+	}
+
+	if err := a.moved(snapshot); err != nil {
+		return false, err
+	}
+
+	if snapshot.Token == *a.pending || snapshot.Exp >= a.pending.Exp {
+		a.log.WithField("version", snapshot.Version).Warn("stored token was changed concurrently, adopting it")
+
+		a.state.Lock()
+		a.current = snapshot
+		a.pending = nil
+		a.state.Unlock()
+
+		a.SlackClient = a.factory(a.current.AccessToken.Reveal())
+
+		return false, nil
+	}
+
+	a.log.WithField("version", snapshot.Version).Warn("stored token was changed concurrently, the rotated token is newer")
+
+	a.state.Lock()
+	a.current.Version = snapshot.Version
+	a.state.Unlock()
+
+	return true, nil
+}
+
+// Run checks the access token right away and then every interval until the
+// context is done. It returns an error only when the token can't be rotated
+// anymore.
+func (a *App) Run(ctx context.Context, interval time.Duration) error {
+	if a.locker != nil {
+		defer func() {
+			ctx, cancel := withTimeout(context.Background(), a.storageTimeout)
+			defer cancel()
+
+			if err := a.locker.Unlock(ctx); err != nil {
+				a.log.WithField("err", err).Error("failed to release rotation lock")
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	moved := make(chan error, 1)
+
+	if a.watcher != nil {
+		go a.watch(ctx, moved)
+	}
+
+	a.log.Info("initial launch of the check")
+	if err := a.Check(ctx); err != nil {
+		return err
+	}
+
+	a.log.Info("launch the ticker")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-moved:
+			return err
+		case <-a.clock.After(interval):
+			a.log.Info("ticker launch of the check")
+			if err := a.Check(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Check saves a pending token, verifies the access token and rotates it when
+// it was rejected. Run calls it every interval.
+func (a *App) Check(ctx context.Context) error {
+	a.run.Lock()
+	defer a.run.Unlock()
+
+	leader, err := a.leader(ctx)
+	if err != nil {
+		a.log.WithField("err", err).Error("failed to check rotation lock")
+
+		return nil
+	}
+
+	if !leader {
+		a.log.Debug("rotation lock is held by another daemon, reloading token")
+
+		return a.follow(ctx)
+	}
+
+	if err := a.checkMoved(ctx); err != nil {
+		return err
+	}
+
+	if a.pending != nil {
+		a.log.Info("saving pending token")
+		if err := a.flush(ctx); errors.Is(err, ErrMoved) {
+			return err
+		} else if err != nil {
+			a.log.WithField("err", err).Error("failed to save pending token")
+			a.emit(audit.SaveFailed, err)
+			a.notify(ctx, notify.SaveFailed, err)
+		} else {
+			a.emit(audit.SaveSucceeded, nil)
+			a.runHooks(ctx)
+		}
+	}
+
+	a.SlackClient = a.factory(a.token().AccessToken.Reveal())
+
+	a.log.Info("checking access token")
+	token, err := a.authTest(ctx)
+	a.emit(audit.Check, err)
+
+	if err != nil {
+		a.log.WithField("err", err).Error("failed to verify current token")
+
+		if err := a.tokenRotate(ctx); err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		return nil
+	}
+
+	a.log.WithFields(log.Fields{
+		"team": token.Team,
+		"user": token.User,
+	}).Debug("access token is valid")
+	a.checkExpiry(ctx)
+
+	return nil
+}
+
+func (a *App) checkExpiry(ctx context.Context) {
+	threshold := a.notifier.ExpiryThreshold()
+	if threshold <= 0 {
+		return
+	}
+
+	exp := a.token().Exp
+	if exp > 0 && time.Unix(exp, 0).Sub(a.clock.Now()) < threshold {
+		a.notify(ctx, notify.ExpirySoon, nil)
+	}
+}
+
+// New reads the tokens from the storage, falling back to opts.Fallback when
+// reading fails, and replays the journal if one is configured.
+func New(ctx context.Context, opts Options) (*App, error) {
+	if opts.Storage == nil {
+		return nil, fmt.Errorf("storage is required")
+	}
+
+	if opts.SlackClientFactory == nil {
+		return nil, fmt.Errorf("slack client factory is required")
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = log.StandardLogger()
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = SystemClock{}
+	}
+
+	if opts.OAuthRefresh == nil {
+		opts.OAuthRefresh = RefreshOAuthToken
+	}
+
+	switch opts.Fallback.Kind {
+	case "", KindConfig, KindOAuth:
+	default:
+		return nil, fmt.Errorf("unknown token kind: %s", opts.Fallback.Kind)
+	}
+
+	a := &App{
+		audit:    opts.Audit,
+		clock:    opts.Clock,
+		factory:  opts.SlackClientFactory,
+		fallback: opts.Fallback,
+		hooks:    opts.Hooks,
+		log:      opts.Logger,
+		notifier: opts.Notifier,
+		storage:  opts.Storage,
+
+		oauthClientID:     opts.OAuthClientID,
+		oauthClientSecret: opts.OAuthClientSecret,
+		oauthRefresh:      opts.OAuthRefresh,
+
+		slackTimeout:   opts.SlackTimeout,
+		storageTimeout: opts.StorageTimeout,
+	}
+
+	if l, ok := opts.Storage.(Locker); ok {
+		a.locker = l
+	}
+
+	if w, ok := opts.Storage.(Watcher); ok {
+		a.watcher = w
+	}
+
+	if opts.Journal != nil {
+		j := &journaled{Storage: a.storage, journal: opts.Journal, log: a.log}
+
+		// only the daemon holding the rotation lock writes to the storage
+		if leader, err := a.leader(ctx); err != nil {
+			a.log.WithField("err", err).Error("failed to check rotation lock, journal is not replayed")
+		} else if !leader {
+			a.log.Info("rotation lock is held by another daemon, journal is not replayed")
+		} else {
+			j.replay(ctx)
+		}
+
+		a.storage = j
+	}
+
+	snapshot, err := a.load(ctx)
+	if err != nil {
+		a.log.WithField("err", err).Error("reading was failed")
+		snapshot = Snapshot{Token: a.fallbackToken()}
+	}
+	a.current = snapshot
+	a.SlackClient = a.factory(a.current.AccessToken.Reveal())
+
+	if err := a.moved(a.current); err != nil {
+		return nil, err
+	}
+
+	if a.kind(a.current.Token) == KindOAuth && (a.oauthClientID == "" || a.oauthClientSecret == "") {
+		return nil, fmt.Errorf("oauth client id and secret are required for oauth tokens")
+	}
+
+	return a, nil
+}
+
+// journaled writes the token to the recovery journal before every store and
+// clears the journal once the backend has accepted the token.
+type journaled struct {
+	Storage
+
+	journal *journal.Journal
+	log     log.FieldLogger
+}
+
+func (j *journaled) Store(ctx context.Context, snapshot Snapshot, prevVersion string) error {
+	if err := j.journal.Write(snapshot.Token); err != nil {
+		j.log.WithField("err", err).Error("failed to write journal")
+	}
+
+	if err := j.Storage.Store(ctx, snapshot, prevVersion); err != nil {
+		return err
+	}
+
+	if err := j.journal.Clear(); err != nil {
+		j.log.WithField("err", err).Error("failed to clear journal")
+	}
+
+	return nil
+}
+
+// replay stores an unflushed token when it is newer than the stored one.
+// A journal older than the storage is left from a save that succeeded
+// after all, or the chain moved on without it, so it is dropped.
+func (j *journaled) replay(ctx context.Context) {
+	token, err := j.journal.Read()
+	if err != nil {
+		j.log.WithField("err", err).Error("failed to read journal")
+
+		return
+	}
+
+	if token == nil {
+		return
+	}
+
+	current, err := j.Load(ctx)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		j.log.WithField("err", err).Error("failed to replay journal")
+
+		return
+	}
+
+	if current.MovedTo != "" {
+		j.log.WithField("moved_to", current.MovedTo).Error("storage was marked as moved, journal is not replayed")
+
+		return
+	}
+
+	if err == nil && (current.Token == *token || current.Exp >= token.Exp) {
+		j.log.WithFields(log.Fields{
+			"exp":        token.Exp,
+			"stored_exp": current.Exp,
+		}).Info("journal is not newer than the stored token, dropping it")
+
+		if err := j.journal.Clear(); err != nil {
+			j.log.WithField("err", err).Error("failed to clear journal")
+		}
+
+		return
+	}
+
+	j.log.Info("replaying unflushed token from the journal")
+
+	if err := j.Store(ctx, Snapshot{Token: *token}, current.Version); err != nil {
+		j.log.WithField("err", err).Error("failed to replay journal")
+	}
+}
@@ -0,0 +1,914 @@
+//go:generate mockgen -source=${GOFILE} -destination=mock/${GOFILE}
+package rotator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/slack-utils/tokens-rotate/internal/audit"
+	"github.com/slack-utils/tokens-rotate/internal/notify"
+	"github.com/slack-utils/tokens-rotate/internal/slacktest"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/rotatortest"
+)
+
+type StorageMock struct {
+	mock.Mock
+}
+
+func (s *StorageMock) Load(_ context.Context) (Snapshot, error) {
+	args := s.Called()
+	return args.Get(0).(Snapshot), args.Error(1)
+}
+func (s *StorageMock) Store(_ context.Context, snapshot Snapshot, prevVersion string) error {
+	args := s.Called(snapshot, prevVersion)
+	return args.Error(0)
+}
+func (s *StorageMock) StorageGetName() string {
+	args := s.Called()
+	return args.Get(0).(string)
+}
+
+type SlackMock struct {
+	mock.Mock
+}
+
+func (s *SlackMock) AuthTestContext(_ context.Context) (*slack.AuthTestResponse, error) {
+	args := s.Called()
+	return args.Get(0).(*slack.AuthTestResponse), args.Error(1)
+}
+func (s *SlackMock) ToolingTokensRotateContext(_ context.Context, token string) (*slack.ToolingTokensRotate, error) {
+	args := s.Called(token)
+	return args.Get(0).(*slack.ToolingTokensRotate), args.Error(1)
+}
+
+type auditSink struct {
+	events []string
+}
+
+func (s *auditSink) Write(data []byte) error {
+	e := audit.Event{}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+
+	s.events = append(s.events, e.Type)
+
+	return nil
+}
+func (s *auditSink) Close() error {
+	return nil
+}
+
+// entryHook sends the messages logged at any level to entries, those
+// nobody reads in time are dropped.
+type entryHook struct {
+	entries chan string
+}
+
+func (h *entryHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *entryHook) Fire(e *log.Entry) error {
+	select {
+	case h.entries <- e.Message:
+	default:
+	}
+
+	return nil
+}
+
+// newLogger returns a debug logger sending its messages to the channel.
+func newLogger() (*log.Logger, <-chan string) {
+	h := &entryHook{entries: make(chan string, 100)}
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	logger.SetLevel(log.DebugLevel)
+	logger.AddHook(h)
+
+	return logger, h.entries
+}
+
+// logged waits until message is logged.
+func logged(t *testing.T, entries <-chan string, message string) {
+	t.Helper()
+
+	for {
+		select {
+		case m := <-entries:
+			if m == message {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q was not logged", message)
+		}
+	}
+}
+
+type notifyRecorder struct {
+	kinds []string
+}
+
+func (n *notifyRecorder) Notify(_ context.Context, notification notify.Notification) error {
+	n.kinds = append(n.kinds, notification.Kind)
+	return nil
+}
+
+type hookRecorder struct {
+	tokens []Token
+}
+
+func (h *hookRecorder) Name() string {
+	return "recorder"
+}
+func (h *hookRecorder) Run(_ context.Context, token Token) error {
+	h.tokens = append(h.tokens, token)
+	return nil
+}
+
+func TestApp(t *testing.T) {
+	stored := Snapshot{
+		Token: Token{
+			AccessToken:  "test-access-token",
+			RefreshToken: "test-refresh-token",
+		},
+		Version: "1",
+	}
+	rotated := Token{
+		AccessToken:  "new-access-token",
+		Exp:          123,
+		RefreshToken: "new-refresh-token",
+	}
+
+	// hooks get the kind of tokens stored without one
+	hooked := rotated
+	hooked.Kind = KindConfig
+
+	tests := []struct {
+		name     string
+		events   []string
+		hooked   []Token
+		notified []string
+		prepare  func(*StorageMock, *SlackMock)
+	}{
+		{
+			name:   "working token",
+			events: []string{audit.Check},
+			prepare: func(s *StorageMock, c *SlackMock) {
+				s.On("Load").Return(stored, nil)
+				c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, nil)
+			},
+		},
+		{
+			name: "renew token",
+			events: []string{
+				audit.Check,
+				audit.RotateAttempted,
+				audit.RotateFailed,
+				audit.EnvFallback,
+				audit.RotateAttempted,
+				audit.RotateFailed,
+				audit.RotateAttempted,
+				audit.RotateSucceeded,
+				audit.SaveSucceeded,
+				audit.HookSucceeded,
+			},
+			hooked: []Token{hooked},
+			// the chain is broken once the fallback token was rejected too
+			notified: []string{notify.RotationFailed, notify.ChainBroken},
+			prepare: func(s *StorageMock, c *SlackMock) {
+				s.On("Load").Return(stored, nil).Once()
+				s.On("Store", Snapshot{Token: rotated}, "1").Return(nil).Once()
+				s.On("Load").Return(Snapshot{Token: rotated, Version: "2"}, nil)
+
+				c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, fmt.Errorf("invalid_auth")).Once()
+				c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, nil)
+				c.On("ToolingTokensRotateContext", "test-refresh-token").Return(&slack.ToolingTokensRotate{}, fmt.Errorf("invalid_refresh_token")).Once()
+				c.On("ToolingTokensRotateContext", "fallback-refresh-token").Return(&slack.ToolingTokensRotate{}, fmt.Errorf("invalid_refresh_token")).Once()
+				c.On("ToolingTokensRotateContext", "fallback-refresh-token").Return(&slack.ToolingTokensRotate{
+					Exp:          rotated.Exp,
+					RefreshToken: rotated.RefreshToken.Reveal(),
+					Token:        rotated.AccessToken.Reveal(),
+				}, nil)
+			},
+		},
+		{
+			name: "fallback token",
+			events: []string{
+				audit.Check,
+				audit.RotateAttempted,
+				audit.RotateFailed,
+				audit.EnvFallback,
+				audit.RotateAttempted,
+				audit.RotateSucceeded,
+				audit.SaveSucceeded,
+				audit.HookSucceeded,
+			},
+			hooked:   []Token{hooked},
+			notified: []string{notify.RotationFailed},
+			prepare: func(s *StorageMock, c *SlackMock) {
+				s.On("Load").Return(stored, nil).Once()
+				s.On("Store", Snapshot{Token: rotated}, "1").Return(nil).Once()
+				s.On("Load").Return(Snapshot{Token: rotated, Version: "2"}, nil)
+
+				c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, fmt.Errorf("invalid_auth")).Once()
+				c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, nil)
+				c.On("ToolingTokensRotateContext", "test-refresh-token").Return(&slack.ToolingTokensRotate{}, fmt.Errorf("invalid_refresh_token")).Once()
+				c.On("ToolingTokensRotateContext", "fallback-refresh-token").Return(&slack.ToolingTokensRotate{
+					Exp:          rotated.Exp,
+					RefreshToken: rotated.RefreshToken.Reveal(),
+					Token:        rotated.AccessToken.Reveal(),
+				}, nil)
+			},
+		},
+		{
+			name: "pending token",
+			events: []string{
+				audit.Check,
+				audit.RotateAttempted,
+				audit.RotateSucceeded,
+				audit.SaveFailed,
+				audit.SaveSucceeded,
+				audit.HookSucceeded,
+			},
+			// the hooks run once the pending token is saved by a later check
+			hooked:   []Token{hooked},
+			notified: []string{notify.SaveFailed},
+			prepare: func(s *StorageMock, c *SlackMock) {
+				s.On("Load").Return(stored, nil).Once()
+				s.On("Store", Snapshot{Token: rotated}, "1").Return(fmt.Errorf("unavailable")).Times(saveRetryLimit)
+				s.On("Store", Snapshot{Token: rotated}, "1").Return(nil).Once()
+				s.On("Load").Return(Snapshot{Token: rotated, Version: "2"}, nil)
+
+				c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, fmt.Errorf("invalid_auth")).Once()
+				c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, nil)
+				c.On("ToolingTokensRotateContext", "test-refresh-token").Return(&slack.ToolingTokensRotate{
+					Exp:          rotated.Exp,
+					RefreshToken: rotated.RefreshToken.Reveal(),
+					Token:        rotated.AccessToken.Reveal(),
+				}, nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &StorageMock{}
+			c := &SlackMock{}
+			sink := &auditSink{}
+			recorder := &hookRecorder{}
+			hooks := &Hooks{}
+			hooks.Add(recorder, time.Second)
+			notifier := &notifyRecorder{}
+
+			if tt.prepare != nil {
+				tt.prepare(s, c)
+			}
+
+			s.On("StorageGetName").Return("test")
+
+			clock := rotatortest.NewClock(time.Unix(0, 0))
+
+			a, err := New(context.Background(), Options{
+				Storage: s,
+				Clock:   clock,
+				SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+					return c
+				},
+				Fallback: Token{
+					AccessToken:  "fallback-access-token",
+					RefreshToken: "fallback-refresh-token",
+				},
+				Audit:    audit.NewWithSinks("", sink),
+				Hooks:    hooks,
+				Notifier: NewNotifier(NotifyOptions{Notifiers: []notify.Notifier{notifier}}),
+			})
+			assert.NoError(t, err)
+
+			assert.NoError(t, simulate(clock, clock.Now().Add(3*time.Minute), func(ctx context.Context) error {
+				return a.Run(ctx, time.Minute)
+			}))
+			s.AssertExpectations(t)
+			c.AssertExpectations(t)
+			assert.Equal(t, tt.events, sink.events[:len(tt.events)])
+			assert.Equal(t, tt.hooked, recorder.tokens)
+			assert.Equal(t, tt.notified, notifier.kinds)
+		})
+	}
+}
+
+func TestAppOAuth(t *testing.T) {
+	s := &StorageMock{}
+	c := &SlackMock{}
+	clock := rotatortest.NewClock(time.Unix(1000, 0))
+
+	stored := Snapshot{
+		Token: Token{
+			AccessToken:  "xoxb-old",
+			Kind:         KindOAuth,
+			RefreshToken: "xoxe-1-old",
+		},
+		Version: "1",
+	}
+	rotated := Token{
+		AccessToken:  "xoxb-new",
+		Exp:          clock.Now().Add(12 * time.Hour).Unix(),
+		Kind:         KindOAuth,
+		RefreshToken: "xoxe-1-new",
+	}
+
+	s.On("StorageGetName").Return("test")
+	s.On("Load").Return(stored, nil).Once()
+	s.On("Store", Snapshot{Token: rotated}, "1").Return(nil).Once()
+	s.On("Load").Return(Snapshot{Token: rotated, Version: "2"}, nil)
+
+	c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, fmt.Errorf("token_expired")).Once()
+	c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, nil)
+
+	refreshed := []string{}
+
+	a, err := New(context.Background(), Options{
+		Storage: s,
+		SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+			return c
+		},
+		Clock:             clock,
+		OAuthClientID:     "client-id",
+		OAuthClientSecret: "client-secret",
+		OAuthRefresh: func(_ context.Context, clientID, clientSecret, refreshToken string) (*slack.OAuthV2Response, error) {
+			assert.Equal(t, "client-id", clientID)
+			assert.Equal(t, "client-secret", clientSecret)
+			refreshed = append(refreshed, refreshToken)
+
+			return &slack.OAuthV2Response{
+				AccessToken:  rotated.AccessToken.Reveal(),
+				ExpiresIn:    int(12 * time.Hour / time.Second),
+				RefreshToken: rotated.RefreshToken.Reveal(),
+			}, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.Check(context.Background()))
+	assert.Equal(t, []string{"xoxe-1-old"}, refreshed)
+	assert.Equal(t, rotated, a.Token())
+	s.AssertExpectations(t)
+	c.AssertExpectations(t)
+
+	_, err = New(context.Background(), Options{
+		Storage: s,
+		SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+			return c
+		},
+		Fallback: Token{Kind: KindOAuth},
+	})
+	assert.Error(t, err)
+}
+
+func TestAppRotate(t *testing.T) {
+	s := &StorageMock{}
+	c := &SlackMock{}
+
+	stored := Snapshot{
+		Token:   Token{AccessToken: "test-access-token", RefreshToken: "test-refresh-token"},
+		Version: "1",
+	}
+	rotated := Token{AccessToken: "new-access-token", Exp: 123, RefreshToken: "new-refresh-token"}
+
+	s.On("StorageGetName").Return("test")
+	s.On("Load").Return(stored, nil).Once()
+	s.On("Store", Snapshot{Token: rotated}, "1").Return(nil).Once()
+	s.On("Load").Return(Snapshot{Token: rotated, Version: "2"}, nil)
+
+	release := make(chan time.Time)
+	c.On("ToolingTokensRotateContext", "test-refresh-token").WaitUntil(release).Return(&slack.ToolingTokensRotate{
+		Exp:          rotated.Exp,
+		RefreshToken: rotated.RefreshToken.Reveal(),
+		Token:        rotated.AccessToken.Reveal(),
+	}, nil).Once()
+
+	logger, entries := newLogger()
+
+	a, err := New(context.Background(), Options{
+		Storage: s,
+		SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+			return c
+		},
+		Logger: logger,
+	})
+	assert.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			token, err := a.Rotate(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, rotated, token)
+		}()
+	}
+
+	// every caller waits for the same rotation
+	for i := 0; i < 5; i++ {
+		logged(t, entries, "waiting for token rotation")
+	}
+
+	close(release)
+	wg.Wait()
+
+	s.AssertExpectations(t)
+	c.AssertExpectations(t)
+}
+
+// releasedSlack signals started and rotates once release is closed, unless
+// the context is done first.
+type releasedSlack struct {
+	release chan struct{}
+	rotated Token
+	started chan struct{}
+}
+
+func (releasedSlack) AuthTestContext(_ context.Context) (*slack.AuthTestResponse, error) {
+	return &slack.AuthTestResponse{}, nil
+}
+func (s releasedSlack) ToolingTokensRotateContext(ctx context.Context, _ string) (*slack.ToolingTokensRotate, error) {
+	s.started <- struct{}{}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.release:
+	}
+
+	return &slack.ToolingTokensRotate{
+		Exp:          s.rotated.Exp,
+		RefreshToken: s.rotated.RefreshToken.Reveal(),
+		Token:        s.rotated.AccessToken.Reveal(),
+	}, nil
+}
+
+func TestAppRotateDetached(t *testing.T) {
+	rotated := Token{AccessToken: "new-access-token", Exp: 123, RefreshToken: "new-refresh-token"}
+	s := &memStorage{
+		name:    "test",
+		stored:  &Token{AccessToken: "test-access-token", RefreshToken: "test-refresh-token"},
+		version: 1,
+	}
+	c := releasedSlack{release: make(chan struct{}), rotated: rotated, started: make(chan struct{}, 1)}
+
+	a, err := New(context.Background(), Options{
+		Storage: s,
+		SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+			return c
+		},
+	})
+	assert.NoError(t, err)
+
+	// the first caller gives up while the rotation is running
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := a.Rotate(ctx)
+		first <- err
+	}()
+
+	<-c.started
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+
+	// the rotation goes on and saves the token
+	close(c.release)
+
+	assert.Eventually(t, func() bool {
+		return a.Token() == rotated && a.Stored()
+	}, time.Second, time.Millisecond)
+}
+
+type hungSlack struct{}
+
+func (hungSlack) AuthTestContext(ctx context.Context) (*slack.AuthTestResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+func (hungSlack) ToolingTokensRotateContext(ctx context.Context, _ string) (*slack.ToolingTokensRotate, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAppCancel(t *testing.T) {
+	s := &StorageMock{}
+	s.On("Load").Return(Snapshot{Token: Token{AccessToken: "test-access-token"}}, nil)
+	s.On("StorageGetName").Return("test")
+
+	a, err := New(context.Background(), Options{
+		Storage: s,
+		SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+			return hungSlack{}
+		},
+		SlackTimeout: time.Minute,
+	})
+	assert.NoError(t, err)
+
+	ctx, stop := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer stop()
+
+	done := make(chan error)
+	go func() {
+		done <- a.Run(ctx, time.Hour)
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run was not interrupted by the context")
+	}
+}
+
+// lockedStorage is a memStorage whose rotation lock is held elsewhere until
+// leader is set.
+type lockedStorage struct {
+	memStorage
+
+	leader   bool
+	unlocked bool
+}
+
+func (l *lockedStorage) Lock(_ context.Context) (bool, error) {
+	return l.leader, nil
+}
+func (l *lockedStorage) Unlock(_ context.Context) error {
+	l.unlocked = true
+	return nil
+}
+
+func TestAppLocker(t *testing.T) {
+	ctx := context.Background()
+	s := &lockedStorage{memStorage: memStorage{
+		name:    "test",
+		stored:  &Token{AccessToken: "old-access-token", RefreshToken: "old-refresh-token"},
+		version: 1,
+	}}
+	c := &SlackMock{}
+
+	a, err := New(ctx, Options{
+		Storage: s,
+		SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+			return c
+		},
+	})
+	assert.NoError(t, err)
+
+	// a follower reloads the token rotated by the leader and never rotates
+	s.stored = &Token{AccessToken: "new-access-token", RefreshToken: "new-refresh-token"}
+	s.version = 2
+
+	assert.NoError(t, a.Check(ctx))
+	assert.Equal(t, *s.stored, a.Token())
+
+	_, err = a.Rotate(ctx)
+	assert.ErrorIs(t, err, ErrNotLeader)
+
+	// the leader checks and rotates
+	s.leader = true
+	c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, nil).Once()
+	c.On("ToolingTokensRotateContext", "new-refresh-token").Return(&slack.ToolingTokensRotate{
+		Exp:          123,
+		RefreshToken: "rotated-refresh-token",
+		Token:        "rotated-access-token",
+	}, nil).Once()
+
+	assert.NoError(t, a.Check(ctx))
+
+	token, err := a.Rotate(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Secret("rotated-access-token"), token.AccessToken)
+	assert.Equal(t, Secret("rotated-access-token"), s.stored.AccessToken)
+
+	// the lock is released when Run returns
+	s.leader = false
+	runCtx, stop := context.WithCancel(ctx)
+	stop()
+	assert.NoError(t, a.Run(runCtx, time.Hour))
+	assert.True(t, s.unlocked)
+
+	c.AssertExpectations(t)
+}
+
+// watchedStorage is a memStorage reporting the snapshots sent on changes.
+type watchedStorage struct {
+	memStorage
+
+	changes chan Snapshot
+}
+
+func (w *watchedStorage) Watch(ctx context.Context) <-chan Snapshot {
+	ch := make(chan Snapshot)
+
+	go func() {
+		defer close(ch)
+
+		for {
+			select {
+			case snapshot := <-w.changes:
+				ch <- snapshot
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+func TestAppWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &watchedStorage{
+		memStorage: memStorage{
+			name:    "test",
+			stored:  &Token{AccessToken: "old-access-token", RefreshToken: "old-refresh-token"},
+			version: 1,
+		},
+		changes: make(chan Snapshot),
+	}
+	c := &SlackMock{}
+	c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, nil)
+
+	a, err := New(ctx, Options{
+		Storage: s,
+		SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+			return c
+		},
+	})
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- a.Run(ctx, time.Hour)
+	}()
+
+	changed := Token{AccessToken: "new-access-token", RefreshToken: "new-refresh-token"}
+	s.changes <- Snapshot{Token: changed, Version: "2"}
+
+	assert.Eventually(t, func() bool {
+		return a.Token() == changed
+	}, time.Second, time.Millisecond)
+
+	// a tombstone stored by migrate stops the daemon
+	s.changes <- Snapshot{Token: Token{MovedTo: "vault"}, Version: "3"}
+	assert.ErrorIs(t, <-done, ErrMoved)
+	assert.Equal(t, changed, a.Token())
+}
+
+func TestAppMoved(t *testing.T) {
+	ctx := context.Background()
+	clock := rotatortest.NewClock(time.Unix(1685800000, 0))
+
+	server := slacktest.New()
+	defer server.Close()
+	server.SetClock(clock.Now)
+
+	access, refresh, exp := server.Issue()
+	src := &memStorage{
+		name:    "src",
+		stored:  &Token{AccessToken: Secret(access), Exp: exp, RefreshToken: Secret(refresh)},
+		version: 1,
+	}
+	dst := &memStorage{name: "dst"}
+	factory := func(token string, options ...slack.Option) SlackClient {
+		return NewSlackClient(token, append(options, slack.OptionAPIURL(server.URL()))...)
+	}
+
+	a, err := New(ctx, Options{
+		Storage:            src,
+		SlackClientFactory: factory,
+		Clock:              clock,
+	})
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- a.Run(ctx, 10*time.Minute)
+	}()
+
+	// the daemon waits for the next check while the tokens are migrated
+	<-clock.Added()
+	assert.NoError(t, Migrate(ctx, src, dst, factory, true))
+
+	// the next check would rotate the chain migrated to dst
+	server.Expire(access)
+	clock.Advance(10 * time.Minute)
+
+	assert.ErrorIs(t, <-done, ErrMoved)
+	assert.Equal(t, 0, server.Calls(slacktest.ToolingTokensRotate))
+	assert.True(t, server.Refreshable(dst.stored.RefreshToken.Reveal()))
+	assert.Equal(t, "dst", src.stored.MovedTo)
+
+	// a daemon started on the moved storage refuses to run
+	_, err = New(ctx, Options{Storage: src, SlackClientFactory: factory})
+	assert.ErrorIs(t, err, ErrMoved)
+}
+
+// racedStorage is a memStorage whose next store loses the race against
+// the concurrent token.
+type racedStorage struct {
+	memStorage
+
+	concurrent *Token
+}
+
+func (r *racedStorage) Store(ctx context.Context, snapshot Snapshot, prevVersion string) error {
+	if r.concurrent != nil {
+		r.stored, r.concurrent = r.concurrent, nil
+		r.version++
+	}
+
+	return r.memStorage.Store(ctx, snapshot, prevVersion)
+}
+
+func TestAppConflict(t *testing.T) {
+	rotated := Token{AccessToken: "rotated-access-token", Exp: 200, RefreshToken: "rotated-refresh-token"}
+	older := Token{AccessToken: "older-access-token", Exp: 100, RefreshToken: "older-refresh-token"}
+	newer := Token{AccessToken: "newer-access-token", Exp: 300, RefreshToken: "newer-refresh-token"}
+
+	tests := []struct {
+		name       string
+		concurrent Token
+		err        error
+		stored     Token
+		token      Token
+	}{
+		{
+			name:       "older token",
+			concurrent: older,
+			stored:     rotated,
+			token:      rotated,
+		},
+		{
+			name:       "newer token",
+			concurrent: newer,
+			stored:     newer,
+			token:      newer,
+		},
+		{
+			name:       "same token",
+			concurrent: rotated,
+			stored:     rotated,
+			token:      rotated,
+		},
+		{
+			name:       "tombstone",
+			concurrent: Token{Exp: 100, MovedTo: "vault"},
+			err:        ErrMoved,
+			stored:     Token{Exp: 100, MovedTo: "vault"},
+			token:      rotated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			concurrent := tt.concurrent
+			s := &racedStorage{
+				memStorage: memStorage{
+					name:    "test",
+					stored:  &Token{AccessToken: "old-access-token", RefreshToken: "old-refresh-token"},
+					version: 1,
+				},
+				concurrent: &concurrent,
+			}
+			c := &SlackMock{}
+			c.On("ToolingTokensRotateContext", "old-refresh-token").Return(&slack.ToolingTokensRotate{
+				Exp:          rotated.Exp,
+				RefreshToken: rotated.RefreshToken.Reveal(),
+				Token:        rotated.AccessToken.Reveal(),
+			}, nil).Once()
+
+			a, err := New(context.Background(), Options{
+				Storage: s,
+				SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+					return c
+				},
+			})
+			assert.NoError(t, err)
+
+			_, err = a.Rotate(context.Background())
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.stored, *s.stored)
+			assert.Equal(t, tt.token, a.Token())
+			assert.Equal(t, tt.err == nil, a.Stored())
+			c.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAppJournal(t *testing.T) {
+	stored := Token{AccessToken: "stored-access-token", Exp: 200, RefreshToken: "stored-refresh-token"}
+	newer := Token{AccessToken: "journal-access-token", Exp: 300, RefreshToken: "journal-refresh-token"}
+	older := Token{AccessToken: "journal-access-token", Exp: 100, RefreshToken: "journal-refresh-token"}
+
+	tests := []struct {
+		name     string
+		journal  Token
+		follower bool
+		stored   Token
+		kept     bool
+	}{
+		{
+			name:    "newer journal",
+			journal: newer,
+			stored:  newer,
+		},
+		{
+			name:    "journal older than the storage",
+			journal: older,
+			stored:  stored,
+		},
+		{
+			name:    "already stored",
+			journal: stored,
+			stored:  stored,
+		},
+		{
+			name:     "follower",
+			journal:  newer,
+			follower: true,
+			stored:   stored,
+			kept:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			j, err := NewJournal(JournalOptions{File: filepath.Join(t.TempDir(), "journal"), Key: "test-key"})
+			assert.NoError(t, err)
+			assert.NoError(t, j.Write(tt.journal))
+
+			token := stored
+			s := &lockedStorage{
+				memStorage: memStorage{name: "test", stored: &token, version: 1},
+				leader:     !tt.follower,
+			}
+
+			_, err = New(ctx, Options{
+				Storage: s,
+				Journal: j,
+				SlackClientFactory: func(_ string, _ ...slack.Option) SlackClient {
+					return &SlackMock{}
+				},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.stored, *s.stored)
+
+			journaled, err := j.Read()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.kept, journaled != nil)
+		})
+	}
+}
+
+func TestVerifyAuthTest(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		rejected bool
+	}{
+		{name: "valid token"},
+		{name: "revoked token", err: slack.SlackErrorResponse{Err: "token_revoked"}, rejected: true},
+		{name: "rate limited", err: &slack.RateLimitedError{RetryAfter: time.Second}},
+		{name: "unknown answer", err: slack.SlackErrorResponse{Err: "fatal_error"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &SlackMock{}
+			c.On("AuthTestContext").Return(&slack.AuthTestResponse{}, tt.err)
+
+			verify := VerifyAuthTest(func(_ string, _ ...slack.Option) SlackClient {
+				return c
+			})
+
+			err := verify(context.Background(), Token{AccessToken: "new-access-token"})
+			assert.Equal(t, tt.err != nil, err != nil)
+			assert.Equal(t, tt.rejected, errors.Is(err, ErrTokenRejected))
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Package rotatortest provides helpers for testing code built on
+// pkg/rotator.
+package rotatortest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a fake rotator.Clock. Time moves only with Advance and Next, so
+// days of rotations run in milliseconds.
+type Clock struct {
+	mu      sync.Mutex
+	added   chan struct{}
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{
+		added: make(chan struct{}, 1),
+		now:   now,
+	}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, waiter{deadline: c.now.Add(d), ch: ch})
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].deadline.Before(c.waiters[j].deadline)
+	})
+
+	select {
+	case c.added <- struct{}{}:
+	default:
+	}
+
+	return ch
+}
+
+// Added is signaled when After registers a waiter.
+func (c *Clock) Added() <-chan struct{} {
+	return c.added
+}
+
+// Waiters returns the number of pending After calls.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+// Deadline returns the earliest pending deadline.
+func (c *Clock) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.waiters) == 0 {
+		return time.Time{}, false
+	}
+
+	return c.waiters[0].deadline, true
+}
+
+// Advance moves the time forward by d and fires the waiters that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(c.now.Add(d))
+}
+
+// Next moves the time to the earliest pending deadline and fires it. It
+// returns false when nothing is waiting.
+func (c *Clock) Next() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.waiters) == 0 {
+		return false
+	}
+
+	c.set(c.waiters[0].deadline)
+
+	return true
+}
+
+func (c *Clock) set(now time.Time) {
+	if now.After(c.now) {
+		c.now = now
+	}
+
+	for len(c.waiters) > 0 && !c.waiters[0].deadline.After(c.now) {
+		c.waiters[0].ch <- c.now
+		c.waiters = c.waiters[1:]
+	}
+}
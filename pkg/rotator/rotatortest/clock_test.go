@@ -0,0 +1,32 @@
+package rotatortest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewClock(start)
+
+	late := c.After(time.Hour)
+	early := c.After(time.Minute)
+	assert.Equal(t, 2, c.Waiters())
+
+	deadline, ok := c.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, start.Add(time.Minute), deadline)
+
+	assert.True(t, c.Next())
+	assert.Equal(t, start.Add(time.Minute), <-early)
+	assert.Equal(t, 1, c.Waiters())
+
+	c.Advance(2 * time.Hour)
+	assert.Equal(t, start.Add(2*time.Hour+time.Minute), <-late)
+	assert.Equal(t, start.Add(2*time.Hour+time.Minute), c.Now())
+	assert.False(t, c.Next())
+
+	assert.Equal(t, c.Now(), <-c.After(0))
+}
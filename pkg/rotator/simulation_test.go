@@ -0,0 +1,228 @@
+package rotator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slack-utils/tokens-rotate/internal/audit"
+	"github.com/slack-utils/tokens-rotate/internal/slacktest"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator/rotatortest"
+)
+
+// simulate runs fn and fires the clock every time fn waits on it, until fn
+// returns or the clock reaches end, which cancels the context of fn.
+func simulate(clock *rotatortest.Clock, end time.Time, fn func(context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-clock.Added():
+		}
+
+		for {
+			deadline, ok := clock.Deadline()
+			if !ok {
+				break
+			}
+
+			if !deadline.Before(end) {
+				cancel()
+				return <-done
+			}
+
+			clock.Next()
+		}
+	}
+}
+
+// flakyStorage fails the given number of stores before passing them on.
+type flakyStorage struct {
+	*memStorage
+
+	failures int
+}
+
+func (f *flakyStorage) Store(ctx context.Context, snapshot Snapshot, prevVersion string) error {
+	if f.failures > 0 {
+		f.failures--
+		return fmt.Errorf("storage is unavailable")
+	}
+
+	return f.memStorage.Store(ctx, snapshot, prevVersion)
+}
+
+func count(events []string, kind string) int {
+	n := 0
+
+	for _, e := range events {
+		if e == kind {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestSimulation(t *testing.T) {
+	start := time.Unix(1685800000, 0)
+
+	tests := []struct {
+		name     string
+		duration time.Duration
+		interval time.Duration
+		// prepare returns the stored and the fallback tokens
+		prepare  func(*testing.T, *slacktest.Server) (Token, Token)
+		failures int
+		error    bool
+		// elapsed is checked when Run fails
+		elapsed   time.Duration
+		rotations int
+		events    map[string]int
+	}{
+		{
+			name:     "rotation every 12 hours for 3 days",
+			duration: 3*24*time.Hour - time.Minute,
+			interval: 10 * time.Minute,
+			prepare: func(t *testing.T, s *slacktest.Server) (Token, Token) {
+				access, refresh, exp := s.Issue()
+				return Token{AccessToken: Secret(access), Exp: exp, RefreshToken: Secret(refresh)}, Token{}
+			},
+			rotations: 5,
+			events: map[string]int{
+				audit.Check:           3 * 24 * 6,
+				audit.RotateSucceeded: 5,
+				audit.SaveSucceeded:   5,
+			},
+		},
+		{
+			name:     "broken chain recovered with fallback tokens",
+			duration: 24 * time.Hour,
+			interval: 10 * time.Minute,
+			prepare: func(t *testing.T, s *slacktest.Server) (Token, Token) {
+				access, refresh, exp := s.Issue()
+				s.Expire(access)
+
+				// the refresh token was used by someone else
+				_, err := slack.New("", slack.OptionAPIURL(s.URL())).ToolingTokensRotate(refresh)
+				require.NoError(t, err)
+
+				fallbackAccess, fallbackRefresh, _ := s.Issue()
+
+				return Token{AccessToken: Secret(access), Exp: exp, RefreshToken: Secret(refresh)},
+					Token{AccessToken: Secret(fallbackAccess), RefreshToken: Secret(fallbackRefresh)}
+			},
+			rotations: 3,
+			events: map[string]int{
+				audit.EnvFallback:     1,
+				audit.RotateFailed:    1,
+				audit.RotateSucceeded: 2,
+			},
+		},
+		{
+			name:     "storage outage longer than the save backoff",
+			duration: time.Hour,
+			interval: 10 * time.Minute,
+			prepare: func(t *testing.T, s *slacktest.Server) (Token, Token) {
+				access, refresh, exp := s.Issue()
+				s.Expire(access)
+				return Token{AccessToken: Secret(access), Exp: exp, RefreshToken: Secret(refresh)}, Token{}
+			},
+			failures:  saveRetryLimit + 2,
+			rotations: 1,
+			events: map[string]int{
+				audit.RotateSucceeded: 1,
+				audit.SaveFailed:      1,
+				audit.SaveSucceeded:   1,
+			},
+		},
+		{
+			name:     "fallback tokens rejected",
+			duration: time.Hour,
+			interval: 10 * time.Minute,
+			prepare: func(t *testing.T, s *slacktest.Server) (Token, Token) {
+				access, _, exp := s.Issue()
+				s.Expire(access)
+				return Token{AccessToken: Secret(access), Exp: exp, RefreshToken: "xoxe-1-unknown"},
+					Token{RefreshToken: "xoxe-1-unknown"}
+			},
+			error:     true,
+			elapsed:   time.Duration(rotateRetryLimit) * time.Second,
+			rotations: rotateRetryLimit + 1,
+			events: map[string]int{
+				audit.EnvFallback:  1,
+				audit.RotateFailed: rotateRetryLimit + 1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, backoff := rotateRetryDelay, saveBackoff
+			t.Cleanup(func() {
+				rotateRetryDelay, saveBackoff = delay, backoff
+			})
+
+			rotateRetryDelay = time.Second
+			saveBackoff = time.Second
+
+			clock := rotatortest.NewClock(start)
+
+			s := slacktest.New()
+			defer s.Close()
+			s.SetClock(clock.Now)
+
+			stored, fallback := tt.prepare(t, s)
+			calls := s.Calls(slacktest.ToolingTokensRotate)
+
+			storage := &flakyStorage{
+				memStorage: &memStorage{name: "memory", stored: &stored, version: 1},
+				failures:   tt.failures,
+			}
+			sink := &auditSink{}
+
+			a, err := New(context.Background(), Options{
+				Storage: storage,
+				SlackClientFactory: func(token string, options ...slack.Option) SlackClient {
+					return NewSlackClient(token, append(options, slack.OptionAPIURL(s.URL()))...)
+				},
+				Clock:    clock,
+				Fallback: fallback,
+				Audit:    audit.NewWithSinks("", sink),
+			})
+			assert.NoError(t, err)
+
+			err = simulate(clock, start.Add(tt.duration), func(ctx context.Context) error {
+				return a.Run(ctx, tt.interval)
+			})
+
+			if tt.error {
+				assert.Error(t, err)
+				assert.Equal(t, tt.elapsed, clock.Now().Sub(start))
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, s.Valid(storage.stored.AccessToken.Reveal()), "stored token must be valid at the end")
+				assert.Equal(t, a.Token(), *storage.stored)
+			}
+
+			assert.Equal(t, tt.rotations, s.Calls(slacktest.ToolingTokensRotate)-calls)
+
+			for kind, n := range tt.events {
+				assert.Equal(t, n, count(sink.events, kind), kind)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+// Package awssecrets provides the AWS Secrets Manager storage of the rotator.
+package awssecrets
+
+import (
+	"context"
+
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/awssecrets"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New returns an AWS Secrets Manager storage. Set opts.Verify, for example
+// to rotator.VerifyAuthTest, to test every new token while it is staged as
+// AWSPENDING.
+func New(ctx context.Context, opts Options) (rotator.Storage, error) {
+	s, err := backend.New(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
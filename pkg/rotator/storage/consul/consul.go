@@ -0,0 +1,19 @@
+// Package consul provides the Consul KV storage of the rotator.
+package consul
+
+import (
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/consul"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New returns a Consul KV storage, it is also a rotator.Locker.
+func New(opts Options) (rotator.Storage, error) {
+	s, err := backend.New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,20 @@
+// Package etcd provides the etcd storage of the rotator.
+package etcd
+
+import (
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/etcd"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New returns an etcd storage, it is also a rotator.Locker and a
+// rotator.Watcher.
+func New(opts Options) (rotator.Storage, error) {
+	s, err := backend.New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
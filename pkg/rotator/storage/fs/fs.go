@@ -0,0 +1,19 @@
+// Package fs provides the local file storage of the rotator.
+package fs
+
+import (
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/fs"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New returns a storage keeping the token in a local file.
+func New(opts Options) (rotator.Storage, error) {
+	s, err := backend.New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
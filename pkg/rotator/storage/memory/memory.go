@@ -0,0 +1,15 @@
+// Package memory provides the in-memory storage of the rotator.
+package memory
+
+import (
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/memory"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New returns a storage keeping the token in memory, for development, tests
+// and callers persisting the token themselves.
+func New(opts Options) rotator.Storage {
+	return backend.New(opts)
+}
@@ -0,0 +1,19 @@
+// Package redis provides the Redis storage of the rotator.
+package redis
+
+import (
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/redis"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New returns a storage keeping the token in a Redis key.
+func New(opts Options) (rotator.Storage, error) {
+	s, err := backend.New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
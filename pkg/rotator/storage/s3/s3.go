@@ -0,0 +1,22 @@
+// Package s3 provides the S3 storage of the rotator.
+package s3
+
+import (
+	"context"
+
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/s3"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New returns a storage keeping the token in an object of an S3-compatible
+// bucket, it is also a rotator.Historian.
+func New(ctx context.Context, opts Options) (rotator.Storage, error) {
+	s, err := backend.New(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
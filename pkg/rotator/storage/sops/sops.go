@@ -0,0 +1,20 @@
+// Package sops provides the sops storage of the rotator.
+package sops
+
+import (
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/sops"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New returns a storage keeping the token in a file encrypted by sops with
+// age or PGP keys.
+func New(opts Options) (rotator.Storage, error) {
+	s, err := backend.New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
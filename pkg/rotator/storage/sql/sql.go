@@ -0,0 +1,22 @@
+// Package sql provides the SQL storage of the rotator.
+package sql
+
+import (
+	"context"
+
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/sql"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New opens the database and applies the schema migrations. The storage is
+// also a rotator.Historian.
+func New(ctx context.Context, opts Options) (rotator.Storage, error) {
+	s, err := backend.New(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
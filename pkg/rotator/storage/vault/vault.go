@@ -0,0 +1,19 @@
+// Package vault provides the Vault storage of the rotator.
+package vault
+
+import (
+	backend "github.com/slack-utils/tokens-rotate/internal/storage/vault"
+	"github.com/slack-utils/tokens-rotate/pkg/rotator"
+)
+
+type Options = backend.Options
+
+// New returns a storage keeping the token in a Vault KV secret.
+func New(opts Options) (rotator.Storage, error) {
+	s, err := backend.New(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,26 @@
+// Package types holds the token types of the rotator without its
+// dependencies, for packages like pkg/client that only pass tokens around.
+// The types are the same as the ones of pkg/rotator.
+package types
+
+import (
+	"context"
+
+	"github.com/slack-utils/tokens-rotate/internal/shared"
+)
+
+type (
+	Secret   = shared.Secret
+	Snapshot = shared.Snapshot
+	Token    = shared.Token
+)
+
+const (
+	KindConfig = shared.KindConfig
+	KindOAuth  = shared.KindOAuth
+)
+
+// Loader reads the stored token, every rotator.Storage is a Loader.
+type Loader interface {
+	Load(context.Context) (Snapshot, error)
+}